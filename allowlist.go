@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+
+	"github.com/jinzhu/gorm"
+)
+
+// AllowlistEntry suppresses matches on known good files
+// An entry with only SHA256 or only PathGlob suppresses every match on the file,
+// an entry with Rule and SHA256 only suppresses that rule on that file.
+type AllowlistEntry struct {
+	gorm.Model
+	SHA256   string `gorm:"index"`
+	PathGlob string
+	Rule     string
+	Note     string
+}
+
+func (entry AllowlistEntry) String() string {
+	switch {
+	case entry.PathGlob != "":
+		return fmt.Sprintf("path %s", entry.PathGlob)
+	case entry.Rule != "":
+		return fmt.Sprintf("rule %s on sha256 %s", entry.Rule, entry.SHA256)
+	}
+	return fmt.Sprintf("sha256 %s", entry.SHA256)
+}
+
+// allowlist is the set of entries a scan checks matches against
+type allowlist []AllowlistEntry
+
+func loadAllowlist(db *gorm.DB) allowlist {
+	var entries allowlist
+	db.Find(&entries)
+	return entries
+}
+
+// allows reports whether a match of rule on the file at path with the given SHA-256 is suppressed
+func (list allowlist) allows(path, sha256, rule string) bool {
+	for _, entry := range list {
+		switch {
+		case entry.PathGlob != "":
+			if matched, _ := filepath.Match(entry.PathGlob, path); matched {
+				return true
+			}
+		case entry.SHA256 != "" && entry.SHA256 == sha256:
+			if entry.Rule == "" || entry.Rule == rule {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// suppress drops allowlisted matches from a result, counting them in Suppressed
+func (list allowlist) suppress(result *scanResult) {
+	if len(list) == 0 || len(result.Matches) == 0 {
+		return
+	}
+	sha256 := ""
+	if result.File != nil {
+		sha256 = result.File.SHA256
+	}
+	var kept []resultMatch
+	for _, match := range result.Matches {
+		if list.allows(result.Path, sha256, match.Rule) {
+			result.Suppressed++
+		} else {
+			kept = append(kept, match)
+		}
+	}
+	result.Matches = kept
+	result.Score = 0
+	if len(kept) > 0 {
+		result.Score = kept[0].Score
+	}
+}
+
+// allowlistCommand handles `allowlist list|add|from-scan <scan id> <path>|remove <id>`
+func allowlistCommand(db *gorm.DB, action string, args []string, entry AllowlistEntry, anyRule bool) {
+	switch action {
+	case "list":
+		var entries []AllowlistEntry
+		db.Order("id").Find(&entries)
+		for _, entry := range entries {
+			fmt.Printf("%4d %s\t%s\n", entry.ID, entry, entry.Note)
+		}
+	case "add":
+		if entry.SHA256 == "" && entry.PathGlob == "" {
+			log.Fatalln("You must specify -sha256 or -path-glob to allowlist.")
+		}
+		if entry.Rule != "" && entry.SHA256 == "" {
+			log.Fatalln("You must specify -sha256 to allowlist a rule on a file.")
+		}
+		allowlistAdd(db, entry)
+	case "from-scan":
+		if len(args) < 2 {
+			log.Fatalln("You must specify a scan ID and the path of one of its results.")
+		}
+		var scan Scan
+		if db.First(&scan, args[0]).Error != nil {
+			log.Fatalf("Scan %s is not in the database", args[0])
+		}
+		resultsPath, err := scan.resultsFile(db)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		found := false
+		err = readResults(resultsPath, func(result *scanResult) {
+			if result.Path != args[1] || found {
+				return
+			}
+			found = true
+			if result.File == nil || len(result.Matches) == 0 {
+				log.Fatalf("%s has no matches with hashes in scan %d", args[1], scan.ID)
+			}
+			if anyRule {
+				allowlistAdd(db, AllowlistEntry{SHA256: result.File.SHA256, Note: entry.Note})
+				return
+			}
+			for _, match := range result.Matches {
+				allowlistAdd(db, AllowlistEntry{SHA256: result.File.SHA256, Rule: match.Rule, Note: entry.Note})
+			}
+		})
+		if err != nil {
+			log.Fatalf("Could not read the results of scan %d: %s", scan.ID, err)
+		}
+		if !found {
+			log.Fatalf("%s is not in the results of scan %d", args[1], scan.ID)
+		}
+	case "remove":
+		id, err := strconv.Atoi(firstArg(args))
+		if err != nil || db.First(&entry, id).Error != nil {
+			log.Fatalln("You must specify the ID of an allowlist entry to remove.")
+		}
+		db.Delete(&entry)
+		fmt.Printf("Removed %s\n", entry)
+	default:
+		log.Fatalln("You must specify an allowlist action (list, add, from-scan, remove).")
+	}
+}
+
+// allowlistAdd adds an entry unless an identical one exists
+func allowlistAdd(db *gorm.DB, entry AllowlistEntry) {
+	var existing AllowlistEntry
+	if !db.Where("sha256 = ? AND path_glob = ? AND rule = ?", entry.SHA256, entry.PathGlob, entry.Rule).First(&existing).RecordNotFound() {
+		return
+	}
+	db.Create(&entry)
+	fmt.Printf("Allowlisted %s\n", entry)
+}
+
+func firstArg(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Archive formats yaya can expand
+const (
+	formatZip  = "zip"
+	formatGzip = "gzip"
+	formatTar  = "tar"
+)
+
+// archiveSeparator joins an archive path and a member name into a virtual path
+const archiveSeparator = "!"
+
+// archiveLimits bound how much a single archive may expand to, to avoid zip bombs
+type archiveLimits struct {
+	depth   int
+	members int
+	size    int64
+}
+
+// archiveBudget tracks what an archive has expanded to so far
+type archiveBudget struct {
+	members int
+	size    int64
+	// skipped are the nested archives that weren't expanded, their siblings still are
+	skipped []error
+}
+
+// archiveFormat identifies an archive from its first bytes
+func archiveFormat(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")) || bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return formatZip
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return formatGzip
+	case len(header) >= 262 && string(header[257:262]) == "ustar":
+		return formatTar
+	}
+	return ""
+}
+
+// limitError is going over the members or size an archive may expand to, which stops the whole archive
+type limitError struct {
+	error
+}
+
+func isLimitError(err error) bool {
+	_, ok := err.(limitError)
+	return ok
+}
+
+// isArchive reports whether the file at path looks like an archive yaya can expand
+func isArchive(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, 262)
+	n, _ := io.ReadFull(f, header)
+	return archiveFormat(header[:n]) != ""
+}
+
+// archiveReader is an archive being expanded, an open file or a member already read into memory
+type archiveReader interface {
+	io.Reader
+	io.ReaderAt
+}
+
+// expandArchiveFile calls fn with each member of the archive at path as it is extracted, named with virtual paths like archive.zip!inner/file.exe
+// The archive is streamed from disk, only the member being scanned is held in memory. It returns what went wrong: nested
+// archives too deep or broken to expand are skipped and the rest are still expanded, going over the member or size limit stops.
+func expandArchiveFile(path string, limits archiveLimits, fn func(member string, data []byte)) []error {
+	f, err := os.Open(path)
+	if err != nil {
+		return []error{err}
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return []error{err}
+	}
+	budget := &archiveBudget{}
+	if err := expandArchive(path, f, info.Size(), 1, limits, budget, fn); err != nil {
+		return append(budget.skipped, err)
+	}
+	return budget.skipped
+}
+
+func expandArchive(name string, r archiveReader, size int64, depth int, limits archiveLimits, budget *archiveBudget, fn func(string, []byte)) error {
+	if depth > limits.depth {
+		return fmt.Errorf("%s: not expanding archives nested more than %d deep", name, limits.depth)
+	}
+
+	// add reads one member, hands it to fn and expands it if it is an archive itself
+	add := func(member string, mr io.Reader) error {
+		budget.members++
+		if budget.members > limits.members {
+			return limitError{fmt.Errorf("%s: archive has more than %d members", name, limits.members)}
+		}
+		content, err := ioutil.ReadAll(io.LimitReader(mr, limits.size-budget.size+1))
+		if err != nil {
+			return fmt.Errorf("%s: %s", member, err)
+		}
+		budget.size += int64(len(content))
+		if budget.size > limits.size {
+			return limitError{fmt.Errorf("%s: archive expands to more than %d bytes", name, limits.size)}
+		}
+		fn(member, content)
+		if archiveFormat(content) == "" {
+			return nil
+		}
+		if depth >= limits.depth {
+			// only this member is left unexpanded, so a deep archive can't hide the ones after it
+			budget.skipped = append(budget.skipped, fmt.Errorf("%s: not expanding archives nested more than %d deep", member, limits.depth))
+			return nil
+		}
+		err = expandArchive(member, bytes.NewReader(content), int64(len(content)), depth+1, limits, budget, fn)
+		if err != nil && !isLimitError(err) {
+			budget.skipped = append(budget.skipped, err)
+			return nil
+		}
+		return err
+	}
+
+	header := make([]byte, 262)
+	n, _ := r.ReadAt(header, 0)
+	switch archiveFormat(header[:n]) {
+	case formatZip:
+		zr, err := zip.NewReader(r, size)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+			err = add(name+archiveSeparator+f.Name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+	case formatGzip:
+		gz, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		defer gz.Close()
+		br := bufio.NewReaderSize(gz, len(header))
+		if peek, _ := br.Peek(len(header)); archiveFormat(peek) == formatTar {
+			// a .tar.gz is expanded as one archive
+			return expandTar(name, br, add)
+		}
+		member := gz.Name
+		if member == "" {
+			member = strings.TrimSuffix(filepath.Base(name), ".gz")
+		}
+		return add(name+archiveSeparator+member, br)
+	case formatTar:
+		return expandTar(name, io.NewSectionReader(r, 0, size), add)
+	}
+	return nil
+}
+
+// expandTar calls add with every regular file in a tar stream
+func expandTar(name string, r io.Reader, add func(string, io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		if err := add(name+archiveSeparator+hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/EFForg/yaya/pkg/yaya"
+)
+
+// printBrokenRules lists the rules that failed to compile grouped by cause
+// Broken rules are revalidated on every update and come back once they compile
+func printBrokenRules(store *yaya.Store) {
+	broken := store.BrokenRules()
+	if len(broken) == 0 {
+		fmt.Println("No broken rules.")
+		return
+	}
+
+	byCause := map[string][]yaya.Rule{}
+	modules := map[string]int{}
+	for _, rule := range broken {
+		cause := yaya.ErrorCause(rule.CompileError)
+		byCause[cause] = append(byCause[cause], rule)
+		if rule.ErrorModule != "" {
+			modules[rule.ErrorModule]++
+		}
+	}
+
+	fmt.Printf("%d broken rules\n", len(broken))
+	for _, cause := range []string{yaya.CauseMissingModule, yaya.CauseUndefinedIdentifier, yaya.CauseSyntax, yaya.CauseOther} {
+		rules := byCause[cause]
+		if len(rules) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s (%d):\n", cause, len(rules))
+		for _, rule := range rules {
+			fmt.Printf("  [%s] %s\n", rule.Ruleset.Name, rule.Path)
+			fmt.Printf("      %s", rule.CompileError)
+			if rule.ErrorAt != nil {
+				fmt.Printf(" (%s)", rule.ErrorAt.Format("2006-01-02 15:04:05"))
+			}
+			fmt.Println()
+		}
+	}
+
+	if len(modules) > 0 {
+		var names []string
+		for name := range modules {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Println("\nyara modules needed:")
+		for _, name := range names {
+			fmt.Printf("  %s - %d rules\n", name, modules[name])
+		}
+	}
+}
+
+// printSlowRules lists the rules scan -disable-slow left out of scans
+func printSlowRules(store *yaya.Store) {
+	slow := store.SlowRules()
+	if len(slow) == 0 {
+		fmt.Println("No slow rules.")
+		return
+	}
+	fmt.Printf("%d slow rules, left out of scans until yara stops warning about them\n", len(slow))
+	for _, rule := range slow {
+		fmt.Printf("  [%s] %s\n", rule.Ruleset.Name, rule.Path)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/EFForg/yaya/pkg/yaya"
+)
+
+// catalogCommand handles `catalog export <file>|import <file>|sync [list]`
+func catalogCommand(rulesets *yaya.RulesetManager, action, path string, apply bool) {
+	if action != "export" && action != "import" && action != "sync" {
+		log.Fatalln("You must specify a catalog action (export, import, sync).")
+	}
+	if action == "sync" {
+		syncCatalog(rulesets, path, apply)
+		return
+	}
+	if path == "" {
+		log.Fatalf("You must specify a catalog file to %s.", action)
+	}
+
+	if action == "export" {
+		catalog := rulesets.ExportCatalog()
+		f := os.Stdout
+		if path != "-" {
+			var err error
+			if f, err = os.Create(path); err != nil {
+				log.Fatalln(err)
+			}
+			defer f.Close()
+		}
+		if err := yaya.WriteCatalog(f, catalog); err != nil {
+			log.Fatalln(err)
+		}
+		log.Printf("Exported %d rulesets to %s", len(catalog.Rulesets), path)
+		return
+	}
+
+	changes, err := importCatalogFile(rulesets, path)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Printf("Added %d rulesets, updated %d (%d with a new URL), %d unchanged, disabled %d rules\n",
+		changes.Added, changes.Updated, changes.Recloned, changes.Unchanged, changes.DisabledRules)
+	if changes.Added > 0 || changes.Recloned > 0 {
+		fmt.Println("Run `update` to download the new and moved rulesets.")
+	}
+}
+
+// syncCatalog compares the rulesets with the awesome-yara list at source, a URL or a saved copy, and applies the changes if asked to
+func syncCatalog(rulesets *yaya.RulesetManager, source string, apply bool) {
+	if source == "" {
+		source = yaya.AwesomeListURL
+	}
+	listed, err := yaya.FetchAwesomeList(source)
+	if err != nil {
+		log.Fatalf("Could not read the awesome-yara list: %s", err)
+	}
+	proposal := rulesets.ProposeSync(listed)
+	fmt.Printf("%d rulesets on the list at %s\n", len(listed), source)
+	if len(proposal.Add) == 0 && len(proposal.Remove) == 0 {
+		fmt.Println("The rulesets are in sync with the list.")
+		return
+	}
+
+	if len(proposal.Add) > 0 {
+		fmt.Printf("\nNew on the list (%d):\n", len(proposal.Add))
+		for _, ruleset := range proposal.Add {
+			fmt.Printf("  + %s <%s>\n      %.100s\n", ruleset.Name, ruleset.URL, ruleset.Description)
+		}
+	}
+	if len(proposal.Remove) > 0 {
+		fmt.Printf("\nNo longer on the list (%d):\n", len(proposal.Remove))
+		for _, ruleset := range proposal.Remove {
+			fmt.Printf("  - %s <%s>\n", ruleset.Name, ruleset.URL)
+		}
+	}
+
+	if !apply {
+		fmt.Println("\nRun `catalog sync -apply` to add the new rulesets and disable the ones no longer listed.")
+		return
+	}
+	added := rulesets.ApplySync(proposal)
+	fmt.Printf("\nAdded %d rulesets and disabled %d, run `update` to download the new ones.\n", added, len(proposal.Remove))
+}
+
+// importCatalogFile merges the catalog at path, - for stdin
+func importCatalogFile(rulesets *yaya.RulesetManager, path string) (yaya.CatalogChanges, error) {
+	f := os.Stdin
+	if path != "-" {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return yaya.CatalogChanges{}, err
+		}
+		defer f.Close()
+	}
+	catalog, err := yaya.ReadCatalog(f)
+	if err != nil {
+		return yaya.CatalogChanges{}, fmt.Errorf("%s: %s", path, err)
+	}
+	return rulesets.ImportCatalog(catalog), nil
+}
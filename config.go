@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/EFForg/yaya/pkg/yaya"
+)
+
+// The config file is config.toml in the config directory, unless YAYA_CONFIG names another file
+const configFileName = "config.toml"
+const configFileEnv = "YAYA_CONFIG"
+
+// scanSection is the config file section that sets defaults for command line options
+const scanSection = "scan"
+
+// configSetting is a top level setting of the config file and the environment variable that overrides it
+type configSetting struct {
+	key  string
+	env  string
+	help string
+}
+
+var configSettings = []configSetting{
+	{key: "config_dir", env: "YAYA_CONFIG_DIR", help: "where yaya keeps its database and rulesets"},
+	{key: "db_path", env: "YAYA_DB_PATH", help: "the database, defaults to yaya.db in config_dir"},
+	{key: "rulesets_dir", env: "YAYA_RULESETS_DIR", help: "where rulesets are cloned, defaults to rulsets in config_dir"},
+	{key: "proxy", env: "YAYA_PROXY", help: "the HTTP proxy for cloning and pulling rulesets"},
+}
+
+// scanSettings are the options the [scan] section may set defaults for
+var scanSettings = []string{
+	"dedup", "profile", "disable-slow", "archives", "archive-depth", "archive-members", "archive-size",
+	"min-size", "max-size", "include", "exclude", "exclude-dir", "follow-symlinks", "one-filesystem",
+	"timeout", "output", "format", "concurrency", "full", "strings", "snippet-bytes", "hash-all",
+	"quarantine", "quarantine-copy", "min-score", "tag", "ruleset", "sort",
+}
+
+// config is the config file merged with the environment
+type config struct {
+	path   string
+	found  bool
+	values map[string]string
+	// sources says where each value came from, the config file or an environment variable
+	sources map[string]string
+}
+
+// scanEnv is the environment variable that overrides a [scan] setting, e.g. YAYA_SCAN_EXCLUDE_DIR
+func scanEnv(option string) string {
+	return "YAYA_SCAN_" + strings.ToUpper(strings.Replace(option, "-", "_", -1))
+}
+
+// loadConfig reads the config file if there is one and applies environment overrides
+func loadConfig() (*config, error) {
+	c := &config{values: map[string]string{}, sources: map[string]string{}}
+	c.path = os.Getenv(configFileEnv)
+	if c.path == "" {
+		dir := os.Getenv("YAYA_CONFIG_DIR")
+		if dir == "" {
+			dir = yaya.DefaultConfigDir()
+		}
+		c.path = path.Join(dir, configFileName)
+	}
+
+	f, err := os.Open(c.path)
+	if err == nil {
+		defer f.Close()
+		values, err := parseConfig(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", c.path, err)
+		}
+		for key, value := range values {
+			if !knownSetting(key) {
+				return nil, fmt.Errorf("%s: unknown setting %q", c.path, key)
+			}
+			c.values[key] = value
+			c.sources[key] = c.path
+		}
+		c.found = true
+	} else if !os.IsNotExist(err) || os.Getenv(configFileEnv) != "" {
+		return nil, err
+	}
+
+	for _, setting := range configSettings {
+		if value, ok := os.LookupEnv(setting.env); ok {
+			c.values[setting.key] = value
+			c.sources[setting.key] = setting.env
+		}
+	}
+	for _, option := range scanSettings {
+		if value, ok := os.LookupEnv(scanEnv(option)); ok {
+			c.values[scanSection+"."+option] = value
+			c.sources[scanSection+"."+option] = scanEnv(option)
+		}
+	}
+	return c, nil
+}
+
+func knownSetting(key string) bool {
+	for _, setting := range configSettings {
+		if key == setting.key {
+			return true
+		}
+	}
+	for _, option := range scanSettings {
+		if key == scanSection+"."+option {
+			return true
+		}
+	}
+	return false
+}
+
+// storeOptions are the options for opening the rule store
+func (c *config) storeOptions() yaya.Options {
+	return yaya.Options{
+		ConfigDir:   expandHome(c.values["config_dir"]),
+		DBPath:      expandHome(c.values["db_path"]),
+		RulesetsDir: expandHome(c.values["rulesets_dir"]),
+	}
+}
+
+// applyDefaults sets the defaults of command line options from the [scan] section
+func (c *config) applyDefaults(flags *flag.FlagSet) error {
+	for _, option := range scanSettings {
+		value, ok := c.values[scanSection+"."+option]
+		if !ok {
+			continue
+		}
+		if err := flags.Set(option, value); err != nil {
+			return fmt.Errorf("%s: %s = %q: %s", c.sources[scanSection+"."+option], option, value, err)
+		}
+	}
+	return nil
+}
+
+// useProxy sends yaya's HTTP traffic through proxy, git's included
+// go-git only lets the transport be set for the whole process, so the command does it rather than the yaya package.
+func useProxy(proxy string) error {
+	if proxy == "" {
+		return nil
+	}
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return fmt.Errorf("bad proxy %q: %s", proxy, err)
+	}
+	// go-git and the awesome-yara list fetch use the default transport
+	http.DefaultTransport.(*http.Transport).Proxy = http.ProxyURL(proxyURL)
+	return nil
+}
+
+// show prints the effective config as a config file, noting where each value came from
+func (c *config) show(store *yaya.Store, flags *flag.FlagSet) {
+	if c.found {
+		fmt.Printf("# config file %s\n", c.path)
+	} else {
+		fmt.Printf("# no config file at %s, the defaults are in use\n", c.path)
+	}
+	opts := store.Options()
+	effective := map[string]string{
+		"config_dir":   opts.ConfigDir,
+		"db_path":      opts.DBPath,
+		"rulesets_dir": opts.RulesetsDir,
+		"proxy":        c.values["proxy"],
+	}
+	for _, setting := range configSettings {
+		fmt.Printf("\n# %s (%s)\n", setting.help, setting.env)
+		fmt.Printf("%s = %s%s\n", setting.key, strconv.Quote(effective[setting.key]), c.source(setting.key))
+	}
+
+	fmt.Printf("\n[%s]\n", scanSection)
+	options := append([]string{}, scanSettings...)
+	sort.Strings(options)
+	for _, option := range options {
+		f := flags.Lookup(option)
+		value := strconv.Quote(f.Value.String())
+		if getter, ok := f.Value.(flag.Getter); ok {
+			switch getter.Get().(type) {
+			case bool, int, int64, uint:
+				value = f.Value.String()
+			}
+		}
+		fmt.Printf("%s = %s%s\n", option, value, c.source(scanSection+"."+option))
+	}
+}
+
+func (c *config) source(key string) string {
+	if source, ok := c.sources[key]; ok {
+		return "  # from " + source
+	}
+	return ""
+}
+
+// parseConfig reads the subset of TOML yaya's config uses: [sections] and key = value pairs of strings, numbers and booleans
+// Keys in a section are returned as section.key.
+func parseConfig(r io.Reader) (map[string]string, error) {
+	values := map[string]string{}
+	section := ""
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: bad section %s", n, line)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		key := strings.TrimSpace(line[:eq])
+		value, err := parseConfigValue(strings.TrimSpace(line[eq+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		if section != "" {
+			key = section + "." + key
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+// parseConfigValue parses a basic "string", a literal 'string', or a bare number or boolean, dropping any trailing comment
+func parseConfigValue(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		end := -1
+		for i := 1; i < len(raw) && end < 0; i++ {
+			switch raw[i] {
+			case '\\':
+				i++
+			case '"':
+				end = i
+			}
+		}
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string %s", raw)
+		}
+		if rest := strings.TrimSpace(raw[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected %s after string", rest)
+		}
+		return strconv.Unquote(raw[:end+1])
+	case strings.HasPrefix(raw, "'"):
+		end := strings.Index(raw[1:], "'") + 1
+		if end <= 0 {
+			return "", fmt.Errorf("unterminated string %s", raw)
+		}
+		if rest := strings.TrimSpace(raw[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected %s after string", rest)
+		}
+		return raw[1:end], nil
+	}
+	if i := strings.Index(raw, "#"); i >= 0 {
+		raw = strings.TrimSpace(raw[:i])
+	}
+	if raw == "true" || raw == "false" {
+		return raw, nil
+	}
+	if _, err := strconv.ParseFloat(strings.Replace(raw, "_", "", -1), 64); err == nil {
+		return strings.Replace(raw, "_", "", -1), nil
+	}
+	return "", fmt.Errorf("unsupported value %s, quote strings", raw)
+}
+
+// expandHome expands a leading ~/ to the home directory
+func expandHome(p string) string {
+	if strings.HasPrefix(p, "~/") {
+		home, _ := os.UserHomeDir()
+		return path.Join(home, p[2:])
+	}
+	return p
+}
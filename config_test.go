@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   map[string]string
+	}{
+		{
+			name:   "empty",
+			config: "",
+			want:   map[string]string{},
+		},
+		{
+			name:   "basic and literal strings",
+			config: "db_path = \"/var/lib/yaya/yaya.db\"\nrulesets_dir = '/var/lib/yaya/rules'\n",
+			want:   map[string]string{"db_path": "/var/lib/yaya/yaya.db", "rulesets_dir": "/var/lib/yaya/rules"},
+		},
+		{
+			name:   "escapes in basic strings",
+			config: `proxy = "http://a\"b\\c\t"`,
+			want:   map[string]string{"proxy": "http://a\"b\\c\t"},
+		},
+		{
+			name:   "literal strings keep backslashes",
+			config: `config_dir = 'C:\Users\yaya'`,
+			want:   map[string]string{"config_dir": `C:\Users\yaya`},
+		},
+		{
+			name:   "comments",
+			config: "# a comment\n  # an indented comment\nproxy = \"http://proxy # not a comment\" # a comment\n[scan]\nconcurrency = 4 # a comment\nfull = true# a comment\n",
+			want:   map[string]string{"proxy": "http://proxy # not a comment", "scan.concurrency": "4", "scan.full": "true"},
+		},
+		{
+			name:   "numbers and booleans",
+			config: "[scan]\nconcurrency = 8\narchive-members = 10_000\nmin-score = -1\narchives = false\n",
+			want:   map[string]string{"scan.concurrency": "8", "scan.archive-members": "10000", "scan.min-score": "-1", "scan.archives": "false"},
+		},
+		{
+			name:   "sections",
+			config: "proxy = \"\"\n[ scan ]\ndedup = \"first\"\n\n[other]\nkey = 1\n",
+			want:   map[string]string{"proxy": "", "scan.dedup": "first", "other.key": "1"},
+		},
+		{
+			name:   "whitespace around keys and values",
+			config: "  proxy=\"p\"  \n\t[scan]\n\ttimeout   =   \"2m\"\t\n",
+			want:   map[string]string{"proxy": "p", "scan.timeout": "2m"},
+		},
+		{
+			name:   "later values win",
+			config: "proxy = \"a\"\nproxy = \"b\"\n",
+			want:   map[string]string{"proxy": "b"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseConfig(strings.NewReader(test.config))
+			if err != nil {
+				t.Fatalf("parseConfig: %s", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseConfig = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{name: "unterminated basic string", config: "proxy = \"http://proxy\n", err: "line 1: unterminated string"},
+		{name: "unterminated literal string", config: "\nproxy = 'http://proxy\n", err: "line 2: unterminated string"},
+		{name: "unquoted string", config: "[scan]\ntimeout = 2m\n", err: "line 2: unsupported value 2m, quote strings"},
+		{name: "text after a string", config: "proxy = \"a\" \"b\"\n", err: "line 1: unexpected \"b\" after string"},
+		{name: "no value", config: "proxy\n", err: "line 1: expected key = value"},
+		{name: "empty value", config: "proxy =\n", err: "line 1: unsupported value"},
+		{name: "bad section", config: "[scan\n", err: "line 1: bad section [scan"},
+		{name: "bad escape", config: `proxy = "\q"`, err: "line 1: invalid syntax"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseConfig(strings.NewReader(test.config))
+			if err == nil {
+				t.Fatalf("parseConfig succeeded, want error %q", test.err)
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("parseConfig error = %q, want %q", err, test.err)
+			}
+		})
+	}
+}
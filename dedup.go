@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/EFForg/yaya/pkg/yaya"
+)
+
+// reportDuplicates logs how many rule files are duplicated across rulesets
+func reportDuplicates(store *yaya.Store) {
+	groups := store.DuplicateRules()
+	if len(groups) > 0 {
+		log.Printf("Found %d rule files vendored in more than one place, run `rules duplicates` for details", len(groups))
+	}
+}
+
+// printDuplicateRules lists groups of identical rule files
+// Duplicates are found a file at a time, so rules that were split across files, merged or edited are not listed.
+func printDuplicateRules(store *yaya.Store) {
+	groups := store.DuplicateRules()
+	if len(groups) == 0 {
+		fmt.Println("No duplicate rules.")
+		return
+	}
+
+	var fingerprints []string
+	for fingerprint := range groups {
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	sort.Slice(fingerprints, func(i, j int) bool {
+		return groups[fingerprints[i]][0].ID < groups[fingerprints[j]][0].ID
+	})
+
+	fmt.Printf("%d duplicated rule files\n", len(groups))
+	fmt.Println("Files are compared whole, ignoring comments and whitespace. Copies of rules that were split into other files, merged or edited are not found.")
+	for _, fingerprint := range fingerprints {
+		fmt.Printf("\n%.12s:\n", fingerprint)
+		for _, rule := range groups[fingerprint] {
+			fmt.Printf("  [%s] %s\n", rule.Ruleset.Name, rule.Path)
+		}
+	}
+}
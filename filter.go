@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Directories that are not worth scanning by default
+const defaultExcludeDirs = ".git,.hg,.svn,node_modules"
+
+// scanFilter decides which files a scan walks into
+type scanFilter struct {
+	minSize        int64
+	maxSize        int64
+	include        []string
+	exclude        []string
+	excludeDirs    []string
+	followSymlinks bool
+	oneFilesystem  bool
+}
+
+// splitList splits a comma separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// parseSize parses a size in bytes with an optional K, M or G suffix
+func parseSize(size string) (int64, error) {
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(strings.ToUpper(size), "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(strings.ToUpper(size), "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(strings.ToUpper(size), "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		size = size[:len(size)-1]
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return n * multiplier, nil
+}
+
+// matchAny reports whether a glob matches either the file name or the whole path
+func matchAny(globs []string, path string) bool {
+	for _, glob := range globs {
+		if matched, _ := filepath.Match(glob, filepath.Base(path)); matched {
+			return true
+		}
+		if matched, _ := filepath.Match(glob, path); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// wantFile reports whether a regular file passes the size and name filters
+func (filter scanFilter) wantFile(path string, info os.FileInfo) bool {
+	if info.Size() < filter.minSize || (filter.maxSize > 0 && info.Size() > filter.maxSize) {
+		return false
+	}
+	if len(filter.include) > 0 && !matchAny(filter.include, path) {
+		return false
+	}
+	return !matchAny(filter.exclude, path)
+}
+
+// describeWalkError explains why a path could not be walked
+func describeWalkError(err error) string {
+	switch {
+	case os.IsNotExist(err):
+		return "file vanished before it could be scanned"
+	case os.IsPermission(err):
+		return "permission denied"
+	}
+	return err.Error()
+}
+
+// walkScanPath calls fn for every regular file under root that passes the filter
+// Symlinks are skipped unless followSymlinks is set, in which case directories
+// already walked are skipped so symlink loops terminate.
+// Like filepath.Walk, paths that can't be read are passed to fn with the error, but
+// the walk carries on with the rest of the tree unless fn returns an error.
+func walkScanPath(root string, filter scanFilter, fn filepath.WalkFunc) error {
+	rootInfo, err := os.Stat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	rootDev, _, _ := fileID(rootInfo)
+	visited := map[[2]uint64]bool{}
+
+	// enterDir reports whether a directory should be walked, marking it visited
+	enterDir := func(path string, info os.FileInfo) bool {
+		if path != root && matchAny(filter.excludeDirs, path) {
+			return false
+		}
+		dev, ino, ok := fileID(info)
+		if !ok {
+			return true
+		}
+		if filter.oneFilesystem && dev != rootDev {
+			log.Printf("Not crossing into another filesystem at %s", path)
+			return false
+		}
+		if visited[[2]uint64{dev, ino}] {
+			log.Printf("Skipping %s, it was already scanned (symlink loop)", path)
+			return false
+		}
+		visited[[2]uint64{dev, ino}] = true
+		return true
+	}
+
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		return filepath.Walk(dir, func(path string, info os.FileInfo, e error) error {
+			if e != nil {
+				if err := fn(path, info, e); err != nil {
+					return err
+				}
+				if info != nil && info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if info.Mode()&os.ModeSymlink != 0 {
+				if !filter.followSymlinks {
+					return nil
+				}
+				target, err := os.Stat(path)
+				if err != nil {
+					Warning(err)
+					return nil
+				}
+				info = target
+				if info.IsDir() {
+					if !enterDir(path, info) {
+						return nil
+					}
+					// a trailing separator makes Walk descend into the symlink target
+					return walk(path + string(os.PathSeparator))
+				}
+			} else if info.IsDir() {
+				if path == dir && dir != root {
+					// already entered through its symlink
+					return nil
+				}
+				if !enterDir(path, info) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			// check if it is a regular file (not dir)
+			if info.Mode().IsRegular() && filter.wantFile(path, info) {
+				return fn(path, info, nil)
+			}
+			return nil
+		})
+	}
+	return walk(root)
+}
@@ -1,4 +1,4 @@
-module yaya
+module github.com/EFForg/yaya
 
 go 1.13
 
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"time"
+)
+
+// Files up to this size are read once for both scanning and hashing
+const preloadLimit = 32 << 20
+
+// resultFile is what analysts pivot on for a scanned file
+type resultFile struct {
+	Size    int64      `json:"size"`
+	ModTime *time.Time `json:"mtime,omitempty"`
+	MD5     string     `json:"md5"`
+	SHA1    string     `json:"sha1"`
+	SHA256  string     `json:"sha256"`
+}
+
+// hashReader computes the MD5, SHA-1 and SHA-256 of everything in r in one pass
+func hashReader(r io.Reader) (*resultFile, error) {
+	md5sum, sha1sum, sha256sum := md5.New(), sha1.New(), sha256.New()
+	size, err := io.Copy(io.MultiWriter(md5sum, sha1sum, sha256sum), r)
+	if err != nil {
+		return nil, err
+	}
+	return &resultFile{
+		Size:   size,
+		MD5:    hex.EncodeToString(md5sum.Sum(nil)),
+		SHA1:   hex.EncodeToString(sha1sum.Sum(nil)),
+		SHA256: hex.EncodeToString(sha256sum.Sum(nil)),
+	}, nil
+}
+
+// hashBytes hashes an in memory buffer
+func hashBytes(data []byte) (*resultFile, error) {
+	return hashReader(bytes.NewReader(data))
+}
+
+// hashFile hashes a file, recording its mtime
+func hashFile(path string) (*resultFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	file, err := hashReader(f)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err == nil {
+		modTime := info.ModTime()
+		file.ModTime = &modTime
+	}
+	return file, nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Scan statuses
+const (
+	scanRunning     = "running"
+	scanCompleted   = "completed"
+	scanInterrupted = "interrupted"
+)
+
+// How many scanned paths are buffered before they are written to the database
+const checkpointBatch = 100
+
+// Scan is a record of a scan in the scan history
+type Scan struct {
+	gorm.Model
+	Path         string
+	Output       string
+	Format       string
+	RulesVersion string
+	// RulesetVersions lists the enabled rulesets and their git commits, one per line
+	RulesetVersions string
+	Status          string
+	Interruptions   int
+	FinishedAt      *time.Time
+}
+
+// resultsFile is where the scan wrote its results, if yaya can read them back and no later scan wrote over them
+func (scan *Scan) resultsFile(db *gorm.DB) (string, error) {
+	switch {
+	case scan.Format == formatCSV:
+		return "", fmt.Errorf("scan %d wrote CSV results, only JSON lines results (-format jsonl) can be read back", scan.ID)
+	case scan.Output == "-":
+		return "", fmt.Errorf("scan %d wrote its results to stdout", scan.ID)
+	}
+	if later := scan.overwrittenBy(db); later != 0 {
+		return "", fmt.Errorf("scan %d wrote its results to %s too, scan %d's results are gone", later, scan.Output, scan.ID)
+	}
+	return scan.Output, nil
+}
+
+// overwrittenBy returns the ID of a later scan that wrote to the same output, replacing this scan's results, or 0
+func (scan *Scan) overwrittenBy(db *gorm.DB) uint {
+	var later Scan
+	if scan.Output == "-" || db.Where("output = ? AND id > ?", scan.Output, scan.ID).Order("id").First(&later).RecordNotFound() {
+		return 0
+	}
+	return later.ID
+}
+
+// ScannedPath records that a scan finished with a path, so it can be skipped when the scan is resumed
+type ScannedPath struct {
+	gorm.Model
+	ScanID uint `gorm:"index"`
+	Path   string
+}
+
+// checkpointer records the paths a scan has finished and the scan's status
+type checkpointer struct {
+	mu      sync.Mutex
+	db      *gorm.DB
+	scan    *Scan
+	done    map[string]bool
+	pending []string
+	// reset is set when a resumed scan starts over because the rules changed
+	reset    bool
+	finished bool
+}
+
+// startScan records a new scan, or reopens the one being resumed with the rules versions of this one
+func startScan(db *gorm.DB, resume uint, scan Scan) *checkpointer {
+	cp := &checkpointer{db: db, scan: &Scan{}, done: map[string]bool{}}
+	if resume == 0 {
+		scan.Status = scanRunning
+		cp.scan = &scan
+		db.Create(cp.scan)
+		log.Printf("Started scan %d, resume it with `scan -resume %d` if it is interrupted", cp.scan.ID, cp.scan.ID)
+		return cp
+	}
+
+	if db.First(cp.scan, resume).Error != nil {
+		log.Fatalf("Scan %d is not in the database", resume)
+	}
+	if cp.scan.Status == scanCompleted {
+		log.Fatalf("Scan %d already completed", resume)
+	}
+	if cp.scan.Status == scanRunning {
+		// the process running it died without recording the interruption
+		cp.scan.Interruptions++
+	}
+	if cp.scan.RulesVersion != scan.RulesVersion {
+		log.Printf("The rules changed since scan %d started, rescanning everything", resume)
+		db.Unscoped().Where("scan_id = ?", resume).Delete(&ScannedPath{})
+		cp.reset = true
+		cp.scan.RulesVersion = scan.RulesVersion
+		cp.scan.RulesetVersions = scan.RulesetVersions
+	} else {
+		var scanned []ScannedPath
+		db.Where("scan_id = ?", resume).Find(&scanned)
+		for _, path := range scanned {
+			cp.done[path.Path] = true
+		}
+		log.Printf("Resuming scan %d, skipping %d paths already scanned", resume, len(cp.done))
+	}
+	cp.scan.Status = scanRunning
+	db.Save(cp.scan)
+	return cp
+}
+
+// scanned reports whether a path was already scanned before the scan was resumed
+func (cp *checkpointer) scanned(path string) bool {
+	return cp.done[path]
+}
+
+// skip marks paths as already scanned
+// Checkpoints are written in batches, so after a hard kill the output has results the checkpoints are missing.
+func (cp *checkpointer) skip(paths []string) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	added := 0
+	for _, path := range paths {
+		if !cp.done[path] {
+			cp.done[path] = true
+			cp.pending = append(cp.pending, path)
+			added++
+		}
+	}
+	if added > 0 {
+		log.Printf("Also skipping %d paths that were written out but not checkpointed", added)
+	}
+}
+
+// checkpoint records that a path has been scanned
+func (cp *checkpointer) checkpoint(path string) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.pending = append(cp.pending, path)
+	if len(cp.pending) >= checkpointBatch {
+		cp.flush()
+	}
+}
+
+// flush writes pending checkpoints, the caller holds mu
+func (cp *checkpointer) flush() {
+	tx := cp.db.Begin()
+	for _, path := range cp.pending {
+		tx.Create(&ScannedPath{ScanID: cp.scan.ID, Path: path})
+	}
+	tx.Commit()
+	cp.pending = nil
+}
+
+// finish records the scan's final status, only the first status is recorded
+func (cp *checkpointer) finish(status string) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if cp.finished {
+		return
+	}
+	cp.finished = true
+	cp.flush()
+	cp.scan.Status = status
+	if status == scanInterrupted {
+		cp.scan.Interruptions++
+	} else {
+		now := time.Now()
+		cp.scan.FinishedAt = &now
+	}
+	cp.db.Save(cp.scan)
+}
+
+// handleInterrupt records the interruption in the scan history when the scan is stopped with a signal
+// drain stops scanning new files and waits for the ones being scanned, a second signal stops without
+// waiting. results is held while exiting so no result is left half written.
+func (cp *checkpointer) handleInterrupt(drain func(), results sync.Locker) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Printf("Stopping once the files being scanned are done, interrupt again to stop now")
+		drained := make(chan struct{})
+		go func() {
+			drain()
+			close(drained)
+		}()
+		select {
+		case <-drained:
+		case <-signals:
+		}
+		results.Lock()
+		cp.finish(scanInterrupted)
+		log.Printf("Scan %d interrupted, resume it with `scan -resume %d`", cp.scan.ID, cp.scan.ID)
+		os.Exit(130)
+	}()
+}
+
+// printScans lists the scan history
+func printScans(db *gorm.DB) {
+	var scans []Scan
+	db.Order("id").Find(&scans)
+	fmt.Printf("%4s %-19s %-11s %8s\t%s\n", "ID", "Started", "Status", "Scanned", "Path")
+	for _, scan := range scans {
+		var count int
+		db.Model(&ScannedPath{}).Where("scan_id = ?", scan.ID).Count(&count)
+		status := scan.Status
+		if scan.Interruptions > 0 {
+			status = fmt.Sprintf("%s (%d interruptions)", status, scan.Interruptions)
+		}
+		fmt.Printf("%4d %-19s %-11s %8d\t%s\n", scan.ID, scan.CreatedAt.Format("2006-01-02 15:04:05"), status, count, scan.Path)
+	}
+}
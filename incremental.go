@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// FileState is what yaya knew about a file the last time it scanned clean
+type FileState struct {
+	gorm.Model
+	Path         string `gorm:"unique_index"`
+	Size         int64
+	ModTime      time.Time
+	SHA256       string
+	RulesVersion string
+}
+
+// unchangedSinceCleanScan reports whether a file scanned clean with the same rules and hasn't changed since
+// The hash is only checked when the size matches but the mtime doesn't.
+func unchangedSinceCleanScan(db *gorm.DB, path string, info os.FileInfo, version string) bool {
+	var state FileState
+	if db.Where("path = ?", path).First(&state).RecordNotFound() {
+		return false
+	}
+	if state.RulesVersion != version || state.Size != info.Size() {
+		return false
+	}
+	if state.ModTime.Equal(info.ModTime()) {
+		return true
+	}
+	file, err := hashFile(path)
+	if err != nil || file.SHA256 != state.SHA256 {
+		return false
+	}
+	state.ModTime = info.ModTime()
+	db.Save(&state)
+	return true
+}
+
+// coverageVersion combines the rules version with the scan options that change what a clean scan covered
+// A file that scanned clean without -archives, or with smaller archive limits, is scanned again.
+func coverageVersion(rulesVersion string, opts scanOptions) string {
+	if !opts.archives {
+		return rulesVersion
+	}
+	return fmt.Sprintf("%s archives:%d,%d,%d", rulesVersion, opts.archive.depth, opts.archive.members, opts.archive.size)
+}
+
+// clean reports whether a scan found nothing at all
+// Matches the filters or the allowlist dropped still count, so the file is scanned again without them
+// or once its allowlist entry is removed.
+func (result *scanResult) clean() bool {
+	return len(result.Matches) == 0 && result.filtered == 0 && result.Suppressed == 0 && len(result.Errors) == 0
+}
+
+// recordFileState remembers a file that scanned clean, and forgets files that didn't
+// file has the hashes of the file, it is nil if they couldn't be computed.
+func recordFileState(db *gorm.DB, path string, info os.FileInfo, version string, clean bool, file *resultFile) {
+	if !clean || file == nil {
+		db.Unscoped().Where("path = ?", path).Delete(&FileState{})
+		return
+	}
+	state := FileState{Path: path}
+	db.Where(FileState{Path: path}).Assign(FileState{
+		Size:         info.Size(),
+		ModTime:      info.ModTime(),
+		SHA256:       file.SHA256,
+		RulesVersion: version,
+	}).FirstOrCreate(&state)
+}
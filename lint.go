@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/EFForg/yaya/pkg/yaya"
+)
+
+// printRuleWarnings lists the compiler warnings for enabled rules grouped by ruleset
+func printRuleWarnings(store *yaya.Store) {
+	warnings := store.RuleWarnings()
+	if len(warnings) == 0 {
+		fmt.Println("No compiler warnings.")
+		return
+	}
+
+	fmt.Printf("%d compiler warnings\n", len(warnings))
+	var lastRuleset, lastPath string
+	for _, warning := range warnings {
+		if warning.Rule.Ruleset.Name != lastRuleset {
+			lastRuleset = warning.Rule.Ruleset.Name
+			fmt.Printf("\n%s:\n", lastRuleset)
+		}
+		if warning.Rule.Path != lastPath {
+			lastPath = warning.Rule.Path
+			fmt.Printf("  %s\n", lastPath)
+		}
+		fmt.Printf("    line %d: %s\n", warning.Line, warning.Text)
+	}
+}
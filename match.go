@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hillu/go-yara/v4"
+)
+
+// The most matched strings written out for one rule match
+const maxMatchStrings = 100
+
+// resultMatch is a rule match as it is written out in scan results
+type resultMatch struct {
+	Rule      string         `json:"rule"`
+	Namespace string         `json:"namespace"`
+	Ruleset   string         `json:"ruleset"`
+	Score     int            `json:"score"`
+	Tags      []string       `json:"tags,omitempty"`
+	Meta      []resultMeta   `json:"meta,omitempty"`
+	Strings   []resultString `json:"strings,omitempty"`
+	// MoreStrings is set when Strings was cut off at maxMatchStrings
+	MoreStrings bool `json:"more_strings,omitempty"`
+}
+
+// resultMeta is a meta field of the matching rule
+type resultMeta struct {
+	Identifier string      `json:"identifier"`
+	Value      interface{} `json:"value"`
+}
+
+// resultString is where a string of the matching rule matched
+type resultString struct {
+	Name   string `json:"name"`
+	Offset uint64 `json:"offset"`
+	Length int    `json:"length"`
+	// Data is a hex encoded snippet of the matched data, at most snippetBytes long
+	Data string `json:"data"`
+}
+
+// newResultMatch converts a yara match, leaving out the matched strings unless snippetBytes is positive
+func newResultMatch(ruleset string, match yara.MatchRule, snippetBytes int) resultMatch {
+	result := resultMatch{Rule: match.Rule, Namespace: match.Namespace, Ruleset: ruleset, Tags: match.Tags}
+	for _, meta := range match.Metas {
+		result.Meta = append(result.Meta, resultMeta{Identifier: meta.Identifier, Value: meta.Value})
+	}
+	if snippetBytes <= 0 {
+		return result
+	}
+	for i, s := range match.Strings {
+		if i == maxMatchStrings {
+			result.MoreStrings = true
+			break
+		}
+		data := s.Data
+		if len(data) > snippetBytes {
+			data = data[:snippetBytes]
+		}
+		result.Strings = append(result.Strings, resultString{
+			Name:   s.Name,
+			Offset: s.Base + s.Offset,
+			Length: len(s.Data),
+			Data:   hex.EncodeToString(data),
+		})
+	}
+	return result
+}
+
+// printMatch prints a match, with its tags, meta and strings when they were asked for
+func printMatch(match resultMatch, details bool) {
+	if !details {
+		log.Printf("  - [%s] %s (score %d)", match.Namespace, match.Rule, match.Score)
+		return
+	}
+	tags := ""
+	if len(match.Tags) > 0 {
+		tags = fmt.Sprintf(" (%s)", strings.Join(match.Tags, ", "))
+	}
+	log.Printf("  - [%s] %s%s (score %d)", match.Namespace, match.Rule, tags, match.Score)
+	for _, meta := range match.Meta {
+		log.Printf("      %s = %q", meta.Identifier, fmt.Sprint(meta.Value))
+	}
+	for _, s := range match.Strings {
+		ellipsis := ""
+		if len(s.Data)/2 < s.Length {
+			ellipsis = "..."
+		}
+		log.Printf("      %s at 0x%x: %s%s", s.Name, s.Offset, s.Data, ellipsis)
+	}
+	if match.MoreStrings {
+		log.Printf("      ... more than %d string matches", maxMatchStrings)
+	}
+}
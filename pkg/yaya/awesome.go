@@ -0,0 +1,195 @@
+package yaya
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AwesomeListURL is the awesome-yara README, the list of open source rulesets yaya's defaults come from
+const AwesomeListURL = "https://raw.githubusercontent.com/InQuest/awesome-yara/master/README.md"
+
+// SourceAwesomeList marks rulesets that came from the awesome-yara list
+const SourceAwesomeList = "awesome-yara"
+
+// awesomeSection is the heading of the list section with rulesets
+const awesomeSection = "Rules"
+
+// awesomeClient fetches the list, giving up on a stalled connection instead of hanging sync
+var awesomeClient = &http.Client{Timeout: time.Minute}
+
+var entryRe = regexp.MustCompile(`^[*-] \[([^\]]+)\]\(([^)\s]+)\)(.*)$`)
+var subItemRe = regexp.MustCompile(`^\s+[*-] (.*)$`)
+var repoRe = regexp.MustCompile(`^https?://(?:www\.)?(github\.com|gitlab\.com|bitbucket\.org)/([^/\s]+)/([^/\s#?]+)`)
+var badgeRe = regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)|:[a-z_]+:`)
+
+// FetchAwesomeList reads the awesome-yara list from a URL, or from a local file such as a saved copy of the README
+func FetchAwesomeList(source string) ([]Ruleset, error) {
+	var r io.Reader
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		resp, err := awesomeClient.Get(source)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: %s", source, resp.Status)
+		}
+		r = resp.Body
+	} else {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	return ParseAwesomeList(r)
+}
+
+// ParseAwesomeList extracts the git repositories listed in the Rules section of the awesome-yara README
+// Entries look like "* [Name](https://github.com/owner/repo)" followed by indented description items.
+// Links into a repository are reduced to the repository, and entries that aren't repositories are skipped.
+func ParseAwesomeList(r io.Reader) ([]Ruleset, error) {
+	var rulesets []Ruleset
+	seen := map[string]bool{}
+	var current *Ruleset
+	inSection := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "## ") {
+			inSection = strings.TrimSpace(strings.TrimPrefix(line, "## ")) == awesomeSection
+			current = nil
+			continue
+		}
+		if !inSection {
+			continue
+		}
+		if m := entryRe.FindStringSubmatch(line); m != nil {
+			current = nil
+			url := repoURL(m[2])
+			if url == "" || seen[normalizeURL(url)] {
+				continue
+			}
+			seen[normalizeURL(url)] = true
+			rulesets = append(rulesets, Ruleset{
+				Name:        strings.TrimSpace(m[1]),
+				URL:         url,
+				Description: strings.TrimLeft(cleanDescription(m[3]), "-: "),
+				Enabled:     true,
+			})
+			current = &rulesets[len(rulesets)-1]
+			continue
+		}
+		if m := subItemRe.FindStringSubmatch(line); m != nil && current != nil {
+			current.Description = strings.TrimSpace(current.Description + " " + cleanDescription(m[1]))
+			continue
+		}
+		if strings.TrimSpace(line) != "" {
+			current = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(rulesets) == 0 {
+		return nil, fmt.Errorf("no rulesets found, is this the awesome-yara README?")
+	}
+	return rulesets, nil
+}
+
+// repoURL returns the clone URL of the repository a link points into, or "" if it isn't a repository
+func repoURL(link string) string {
+	m := repoRe.FindStringSubmatch(link)
+	if m == nil {
+		return ""
+	}
+	return fmt.Sprintf("https://%s/%s/%s.git", m[1], m[2], strings.TrimSuffix(m[3], ".git"))
+}
+
+// normalizeURL makes different spellings of a repository URL compare equal
+func normalizeURL(url string) string {
+	url = strings.ToLower(strings.TrimSpace(url))
+	url = strings.TrimPrefix(strings.TrimPrefix(url, "https://"), "http://")
+	url = strings.TrimPrefix(url, "www.")
+	return strings.TrimSuffix(strings.TrimSuffix(url, "/"), ".git")
+}
+
+func cleanDescription(text string) string {
+	return strings.Join(strings.Fields(badgeRe.ReplaceAllString(text, "")), " ")
+}
+
+// SyncProposal is how the rulesets differ from the awesome-yara list
+type SyncProposal struct {
+	// Add are listed rulesets yaya doesn't have
+	Add []Ruleset
+	// Remove are enabled rulesets that came from the list but are no longer on it
+	Remove []Ruleset
+}
+
+// ProposeSync compares the rulesets with the listed ones
+// Rulesets are matched by repository URL, and only rulesets that came from the list are proposed for removal, never custom ones.
+func (m *RulesetManager) ProposeSync(listed []Ruleset) SyncProposal {
+	var proposal SyncProposal
+	have := map[string]bool{}
+	for _, ruleset := range m.All() {
+		have[normalizeURL(ruleset.URL)] = true
+	}
+	onList := map[string]bool{}
+	for _, ruleset := range listed {
+		onList[normalizeURL(ruleset.URL)] = true
+		if !have[normalizeURL(ruleset.URL)] {
+			proposal.Add = append(proposal.Add, ruleset)
+		}
+	}
+
+	defaults := map[string]bool{}
+	for _, ruleset := range DefaultRulesets {
+		defaults[normalizeURL(ruleset.URL)] = true
+	}
+	for _, ruleset := range m.Enabled() {
+		url := normalizeURL(ruleset.URL)
+		fromList := ruleset.Source == SourceAwesomeList || defaults[url]
+		if fromList && !onList[url] {
+			proposal.Remove = append(proposal.Remove, ruleset)
+		}
+	}
+	sort.Slice(proposal.Add, func(i, j int) bool { return proposal.Add[i].Name < proposal.Add[j].Name })
+	return proposal
+}
+
+// ApplySync adds the proposed rulesets and disables the ones proposed for removal, keeping their rules and history
+// It returns how many rulesets were added, ones whose name isn't a directory name are skipped.
+func (m *RulesetManager) ApplySync(proposal SyncProposal) int {
+	db := m.store.db
+	added := 0
+	for _, ruleset := range proposal.Add {
+		ruleset.Source = SourceAwesomeList
+		var existing Ruleset
+		if !db.Where("name = ?", ruleset.Name).First(&existing).RecordNotFound() {
+			// another repository already has the name
+			ruleset.Name = fmt.Sprintf("%s (%s)", ruleset.Name, strings.TrimSuffix(ruleset.URL[strings.LastIndex(ruleset.URL, "/")+1:], ".git"))
+		}
+		if err := checkRulesetName(ruleset.Name); err != nil {
+			m.store.warn(fmt.Errorf("could not add %s: %s", ruleset.URL, err))
+			continue
+		}
+		if err := db.Create(&ruleset).Error; err != nil {
+			m.store.warn(fmt.Errorf("could not add %s: %s", ruleset.Name, err))
+			continue
+		}
+		added++
+	}
+	for _, ruleset := range proposal.Remove {
+		db.Model(&ruleset).Update("enabled", false)
+	}
+	return added
+}
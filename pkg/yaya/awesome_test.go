@@ -0,0 +1,107 @@
+package yaya
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseAwesomeList(t *testing.T) {
+	f, err := os.Open("testdata/awesome-yara.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rulesets, err := ParseAwesomeList(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Ruleset{
+		{Name: "BinSequencer", URL: "https://github.com/karttoon/binsequencer.git", Description: "Find a common pattern of bytes within a set of samples and generate a YARA rule.", Enabled: true},
+		{Name: "CDI Rules", URL: "https://github.com/CyberDefenses/CDI_yara.git", Description: "Collection of YARA rules released publicly by CyberDefenses.", Enabled: true},
+		{Name: "Subdirectory", URL: "https://github.com/someone/Mixed-Rules.git", Description: "Rules in a subdirectory", Enabled: true},
+		{Name: "GitLab Rules", URL: "https://gitlab.com/group/rules.git", Enabled: true},
+		{Name: "Bitbucket", URL: "https://bitbucket.org/team/sigs.git", Enabled: true},
+	}
+	if !reflect.DeepEqual(rulesets, want) {
+		t.Errorf("ParseAwesomeList =\n%+v\nwant\n%+v", rulesets, want)
+	}
+}
+
+func TestParseAwesomeListEntries(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry string
+		want  []Ruleset
+	}{
+		{
+			name:  "repository",
+			entry: "* [Rules](https://github.com/owner/repo)",
+			want:  []Ruleset{{Name: "Rules", URL: "https://github.com/owner/repo.git", Enabled: true}},
+		},
+		{
+			name:  "description after a dash",
+			entry: "- [Rules](https://github.com/owner/repo) - Some rules",
+			want:  []Ruleset{{Name: "Rules", URL: "https://github.com/owner/repo.git", Description: "Some rules", Enabled: true}},
+		},
+		{
+			name:  "badges and emoji",
+			entry: "* [Rules](https://github.com/owner/repo) :star: ![Stars](https://img.shields.io/x) Some :fire: rules",
+			want:  []Ruleset{{Name: "Rules", URL: "https://github.com/owner/repo.git", Description: "Some rules", Enabled: true}},
+		},
+		{
+			name:  "description items",
+			entry: "* [Rules](https://github.com/owner/repo)\n    * Some rules\n\n    - by someone",
+			want:  []Ruleset{{Name: "Rules", URL: "https://github.com/owner/repo.git", Description: "Some rules by someone", Enabled: true}},
+		},
+		{
+			name:  "link into a repository",
+			entry: "* [Rules](https://github.com/owner/repo/tree/master/rules/yara)",
+			want:  []Ruleset{{Name: "Rules", URL: "https://github.com/owner/repo.git", Enabled: true}},
+		},
+		{
+			name:  "clone URL",
+			entry: "* [Rules](https://gitlab.com/owner/repo.git)",
+			want:  []Ruleset{{Name: "Rules", URL: "https://gitlab.com/owner/repo.git", Enabled: true}},
+		},
+		{
+			name:  "duplicate in another spelling",
+			entry: "* [Rules](https://github.com/owner/repo)\n* [Again](http://www.github.com/Owner/Repo/)",
+			want:  []Ruleset{{Name: "Rules", URL: "https://github.com/owner/repo.git", Enabled: true}},
+		},
+		{
+			name:  "not a repository",
+			entry: "* [Blog](https://blog.example.com/yara)\n* [Rules](https://github.com/owner/repo)",
+			want:  []Ruleset{{Name: "Rules", URL: "https://github.com/owner/repo.git", Enabled: true}},
+		},
+		{
+			name:  "text ends the description",
+			entry: "* [Rules](https://github.com/owner/repo)\nA paragraph.\n    * Not about the rules",
+			want:  []Ruleset{{Name: "Rules", URL: "https://github.com/owner/repo.git", Enabled: true}},
+		},
+		{
+			name:  "subsection",
+			entry: "### Vendor\n* [Rules](https://github.com/owner/repo)",
+			want:  []Ruleset{{Name: "Rules", URL: "https://github.com/owner/repo.git", Enabled: true}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			list := "* [Before](https://github.com/before/list)\n## Rules\n" + test.entry + "\n## Tools\n* [After](https://github.com/after/list)\n"
+			got, err := ParseAwesomeList(strings.NewReader(list))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ParseAwesomeList =\n%+v\nwant\n%+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseAwesomeListEmpty(t *testing.T) {
+	if _, err := ParseAwesomeList(strings.NewReader("# Not the list\n## Tools\n* [After](https://github.com/after/list)\n")); err == nil {
+		t.Error("ParseAwesomeList succeeded without a Rules section")
+	}
+}
@@ -0,0 +1,146 @@
+package yaya
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// catalogVersion is the version of the catalog format written by WriteCatalog
+const catalogVersion = 1
+
+// Catalog is a portable list of rulesets and the rules disabled in them, for sharing a curated set of rules
+// Rulesets are always cloned from the default branch and scanned whole, so a catalog has no git ref or path filters.
+type Catalog struct {
+	Version  int              `json:"version"`
+	Rulesets []CatalogRuleset `json:"rulesets"`
+}
+
+// CatalogRuleset is a ruleset in a catalog
+type CatalogRuleset struct {
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+	Enabled     bool   `json:"enabled"`
+	// DisabledRules are rule files disabled by hand, relative to the ruleset's directory
+	DisabledRules []string `json:"disabled_rules,omitempty"`
+}
+
+// CatalogChanges counts what importing a catalog changed
+type CatalogChanges struct {
+	Added   int
+	Updated int
+	// Recloned counts the updated rulesets whose URL changed, their clones were removed so the next update clones the new URL
+	Recloned      int
+	Unchanged     int
+	DisabledRules int
+}
+
+// ReadCatalog reads a catalog written by WriteCatalog
+// Ruleset names must be directory names and disabled rules must be inside their ruleset, a catalog can't reach outside the rulesets directory.
+func ReadCatalog(r io.Reader) (*Catalog, error) {
+	var catalog Catalog
+	if err := json.NewDecoder(r).Decode(&catalog); err != nil {
+		return nil, fmt.Errorf("bad catalog: %s", err)
+	}
+	if catalog.Version > catalogVersion {
+		return nil, fmt.Errorf("catalog version %d is newer than this yaya understands", catalog.Version)
+	}
+	// catalogs are shared between people, names and rule paths become paths on disk so they must stay in the rulesets directory
+	for _, ruleset := range catalog.Rulesets {
+		if ruleset.Name == "" || ruleset.URL == "" {
+			return nil, fmt.Errorf("bad catalog: every ruleset needs a name and url")
+		}
+		if err := checkRulesetName(ruleset.Name); err != nil {
+			return nil, fmt.Errorf("bad catalog: %s", err)
+		}
+		for _, rel := range ruleset.DisabledRules {
+			clean := filepath.Clean(filepath.FromSlash(rel))
+			if rel == "" || filepath.IsAbs(clean) || strings.HasPrefix(rel, "/") || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+				return nil, fmt.Errorf("bad catalog: disabled rule %q of %s is not inside the ruleset", rel, ruleset.Name)
+			}
+		}
+	}
+	return &catalog, nil
+}
+
+// WriteCatalog writes a catalog as indented JSON
+func WriteCatalog(w io.Writer, catalog *Catalog) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(catalog)
+}
+
+// ExportCatalog lists every ruleset and the rules disabled in it
+// Rules disabled because they failed to compile are left out, they are found broken again wherever the catalog is imported.
+func (m *RulesetManager) ExportCatalog() *Catalog {
+	catalog := &Catalog{Version: catalogVersion}
+	var rulesets []Ruleset
+	m.store.db.Order("name").Find(&rulesets)
+	for _, ruleset := range rulesets {
+		entry := CatalogRuleset{Name: ruleset.Name, URL: ruleset.URL, Description: ruleset.Description, Enabled: ruleset.Enabled}
+		var disabled []Rule
+		m.store.db.Where("ruleset_id = ? AND enabled = ?", ruleset.ID, false).Find(&disabled)
+		for _, rule := range disabled {
+			rel, err := filepath.Rel(m.Path(&ruleset), rule.Path)
+			if err != nil {
+				m.store.warn(err)
+				continue
+			}
+			entry.DisabledRules = append(entry.DisabledRules, filepath.ToSlash(rel))
+		}
+		sort.Strings(entry.DisabledRules)
+		catalog.Rulesets = append(catalog.Rulesets, entry)
+	}
+	return catalog
+}
+
+// ImportCatalog merges a catalog into the store, importing the same catalog twice changes nothing
+// Rulesets are added or updated to match the catalog and the rules it disables are disabled, even
+// before the ruleset is cloned. Rulesets and disabled rules that are not in the catalog are kept. A ruleset
+// whose URL changed has its clone removed, so the next Update clones it from the new URL.
+func (m *RulesetManager) ImportCatalog(catalog *Catalog) CatalogChanges {
+	var changes CatalogChanges
+	db := m.store.db
+	for _, entry := range catalog.Rulesets {
+		var ruleset Ruleset
+		if db.Where("name = ?", entry.Name).First(&ruleset).RecordNotFound() {
+			ruleset = Ruleset{Name: entry.Name, URL: entry.URL, Description: entry.Description, Enabled: entry.Enabled}
+			db.Create(&ruleset)
+			if !entry.Enabled {
+				// gorm leaves out the false value and the column defaults to true
+				db.Model(&ruleset).Update("enabled", false)
+			}
+			changes.Added++
+		} else if ruleset.URL != entry.URL || ruleset.Description != entry.Description || ruleset.Enabled != entry.Enabled {
+			if ruleset.URL != entry.URL {
+				// pulling would keep fetching from the old remote
+				if rulesetPath := m.Path(&ruleset); m.inDir(rulesetPath) {
+					m.log.Printf("%s moved from %s to %s, removing its clone", ruleset.Name, ruleset.URL, entry.URL)
+					m.store.warn(os.RemoveAll(rulesetPath))
+					changes.Recloned++
+				} else {
+					m.store.warn(fmt.Errorf("ruleset %q is outside %s, not removing it", ruleset.Name, m.dir))
+				}
+			}
+			db.Model(&ruleset).Updates(map[string]interface{}{"url": entry.URL, "description": entry.Description, "enabled": entry.Enabled})
+			changes.Updated++
+		} else {
+			changes.Unchanged++
+		}
+
+		for _, rel := range entry.DisabledRules {
+			rule := Rule{Path: filepath.Join(m.Path(&ruleset), filepath.FromSlash(rel)), RulesetID: ruleset.ID}
+			db.FirstOrCreate(&rule, rule)
+			if rule.Enabled {
+				db.Model(&rule).Update("enabled", false)
+				changes.DisabledRules++
+			}
+		}
+	}
+	return changes
+}
@@ -0,0 +1,132 @@
+package yaya
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Dedup policies
+const (
+	DedupNone   = ""
+	DedupFirst  = "first"
+	DedupPrefer = "prefer:"
+)
+
+// fingerprintRule hashes a rule file ignoring comments and whitespace so vendored copies of the same file match
+// Rule files are compiled and deduplicated whole, so a file is only a duplicate when all of it is, not when it shares some rules with another.
+func fingerprintRule(body []byte) string {
+	stripped := stripComments(body)
+	normalized := strings.Join(strings.Fields(string(stripped)), " ")
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// stripComments removes // and /* */ comments from a rule file, leaving "strings" and /regexes/ alone even when they contain // or /*
+// YARA divides with \, so outside comments a / always starts a regex. Strings and regexes can't span lines, an unterminated
+// one ends at the end of the line so a broken file can't hide the rest of itself.
+func stripComments(body []byte) []byte {
+	out := make([]byte, 0, len(body))
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case c == '/' && i+1 < len(body) && body[i+1] == '/':
+			for i < len(body) && body[i] != '\n' {
+				i++
+			}
+			if i < len(body) {
+				out = append(out, '\n')
+			}
+		case c == '/' && i+1 < len(body) && body[i+1] == '*':
+			end := bytes.Index(body[i+2:], []byte("*/"))
+			if end < 0 {
+				return out
+			}
+			// keep the comment separating what is on either side of it
+			out = append(out, ' ')
+			i += 2 + end + 1
+		case c == '"' || c == '/':
+			// copy the string or regex up to its unescaped closing quote or slash
+			out = append(out, c)
+			for i++; i < len(body) && body[i] != '\n'; i++ {
+				out = append(out, body[i])
+				if body[i] == '\\' && i+1 < len(body) && body[i+1] != '\n' {
+					i++
+					out = append(out, body[i])
+				} else if body[i] == c {
+					break
+				}
+			}
+			if i < len(body) && body[i] == '\n' {
+				out = append(out, '\n')
+			}
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// ValidDedupPolicy reports whether policy is one of the known dedup policies
+func ValidDedupPolicy(policy string) bool {
+	return policy == DedupNone || policy == DedupFirst ||
+		(strings.HasPrefix(policy, DedupPrefer) && len(policy) > len(DedupPrefer))
+}
+
+// DuplicateRules returns the enabled rule files whose content also appears in another rule file, grouped by fingerprint
+func (s *Store) DuplicateRules() map[string][]Rule {
+	var dups []Rule
+	s.db.Select("rules.*").Joins("JOIN rulesets ON rulesets.id = rules.ruleset_id").
+		Where("rulesets.enabled = ? AND rules.enabled = ? AND rules.broken = ? AND rules.slow = ? AND rules.fingerprint <> ''", true, true, false, false).
+		Where("rules.fingerprint IN ?", s.db.Table("rules").Select("fingerprint").
+			Where("enabled = ? AND broken = ? AND slow = ? AND deleted_at IS NULL", true, false, false).
+			Group("fingerprint").Having("COUNT(*) > 1").SubQuery()).
+		Preload("Ruleset").Order("rules.ruleset_id, rules.id").Find(&dups)
+
+	groups := map[string][]Rule{}
+	for _, rule := range dups {
+		groups[rule.Fingerprint] = append(groups[rule.Fingerprint], rule)
+	}
+	for fingerprint, group := range groups {
+		if len(group) < 2 {
+			delete(groups, fingerprint)
+		}
+	}
+	return groups
+}
+
+// DuplicateRuleIDs returns the IDs of rules to skip under the given dedup policy
+// Every group of duplicates keeps one rule: the one from the preferred ruleset if
+// there is one, otherwise the first one added.
+func (s *Store) DuplicateRuleIDs(policy string) map[uint]bool {
+	skip := map[uint]bool{}
+	if policy == DedupNone {
+		return skip
+	}
+	preferred := strings.TrimPrefix(policy, DedupPrefer)
+	if strings.HasPrefix(policy, DedupPrefer) && s.db.Where("name = ?", preferred).First(&Ruleset{}).RecordNotFound() {
+		s.warn(fmt.Errorf("there is no ruleset %q to prefer, keeping the first of each duplicate instead", preferred))
+	}
+	for _, group := range s.DuplicateRules() {
+		keep := group[0]
+		if strings.HasPrefix(policy, DedupPrefer) {
+			for _, rule := range group {
+				if rule.Ruleset.Name == preferred {
+					keep = rule
+					break
+				}
+			}
+		}
+		for _, rule := range group {
+			if rule.ID != keep.ID {
+				skip[rule.ID] = true
+			}
+		}
+	}
+	if len(skip) > 0 {
+		s.log.Printf("Skipping %d duplicate rule files (dedup policy %q)", len(skip), policy)
+	}
+	return skip
+}
@@ -0,0 +1,58 @@
+package yaya
+
+import "testing"
+
+func TestFingerprintRule(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		same bool
+	}{
+		{
+			name: "comments and whitespace",
+			a:    "rule a { condition: true }",
+			b:    "// a rule\nrule a {\n\t/* always */ condition:  true // matches\n}\n",
+			same: true,
+		},
+		{
+			name: "URL strings",
+			a:    `rule a { strings: $s = "http://evil.example/one" condition: $s }`,
+			b:    `rule a { strings: $s = "http://good.example/two" condition: $s }`,
+		},
+		{
+			name: "comment start in a string",
+			a:    `rule a { strings: $s = "/* one" condition: $s } rule b { condition: true }`,
+			b:    `rule a { strings: $s = "/* two" condition: $s } rule b { condition: true }`,
+		},
+		{
+			name: "escaped quote in a string",
+			a:    `rule a { strings: $s = "\"//" $t = "one" condition: all of them }`,
+			b:    `rule a { strings: $s = "\"//" $t = "two" condition: all of them }`,
+		},
+		{
+			name: "regexes",
+			a:    `rule a { strings: $r = /https?:\/\/evil/ condition: $r }`,
+			b:    `rule a { strings: $r = /https?:\/\/good/ condition: $r }`,
+		},
+		{
+			name: "comment after a regex",
+			a:    "rule a { strings: $r = /a\\/b/ // one\n condition: $r }",
+			b:    "rule a { strings: $r = /a\\/b/ /* two */\n condition: $r }",
+			same: true,
+		},
+		{
+			name: "comments in hex strings",
+			a:    "rule a { strings: $h = { 4D 5A /* MZ */ } condition: $h }",
+			b:    "rule a { strings: $h = { 4D 5A } condition: $h }",
+			same: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			same := fingerprintRule([]byte(test.a)) == fingerprintRule([]byte(test.b))
+			if same != test.same {
+				t.Errorf("same fingerprint = %v, want %v\n%s\n%s", same, test.same, stripComments([]byte(test.a)), stripComments([]byte(test.b)))
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
-package main
+package yaya
 
-var defaultRulesets = [...]Ruleset{
+// DefaultRulesets are installed the first time yaya runs, they come from the awesome-yara list
+var DefaultRulesets = [...]Ruleset{
 	Ruleset{Name: "BinSequencer", URL: "https://github.com/karttoon/binsequencer.git", Description: "Find a common pattern of bytes within a set of samples and generate a YARA rule from the identified pattern.", Enabled: true},
 	Ruleset{Name: "CAPE Rules", URL: "https://github.com/ctxis/CAPE.git", Description: "Rules from various authors bundled with the Config And Payload Extraction Cuckoo Sandbox extension (see next section).", Enabled: true},
 	Ruleset{Name: "CDI Rules", URL: "https://github.com/CyberDefenses/CDI_yara.git", Description: "Collection of YARA rules released by [CyberDefenses](https://cyberdefenses.com/blog/) for public use. Built from information in intelligence profiles, dossiers and file work.", Enabled: true},
@@ -0,0 +1,47 @@
+package yaya
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Causes of rule compile failures
+const (
+	CauseMissingModule       = "missing module"
+	CauseUndefinedIdentifier = "undefined identifier"
+	CauseSyntax              = "syntax error"
+	CauseOther               = "other"
+)
+
+// yaraModules are the modules that can be compiled into libyara
+var yaraModules = map[string]bool{
+	"console": true, "cuckoo": true, "dex": true, "dotnet": true, "elf": true, "hash": true,
+	"macho": true, "magic": true, "math": true, "pe": true, "string": true, "time": true,
+}
+
+var unknownModuleRe = regexp.MustCompile(`unknown module "([^"]+)"`)
+var undefinedIdentifierRe = regexp.MustCompile(`undefined identifier "([^".]+)`)
+
+// errorModule returns the yara module a compile error says is needed, if any
+func errorModule(msg string) string {
+	if m := unknownModuleRe.FindStringSubmatch(msg); m != nil {
+		return m[1]
+	}
+	if m := undefinedIdentifierRe.FindStringSubmatch(msg); m != nil && yaraModules[m[1]] {
+		return m[1]
+	}
+	return ""
+}
+
+// ErrorCause classifies a compile error message
+func ErrorCause(msg string) string {
+	switch {
+	case unknownModuleRe.MatchString(msg):
+		return CauseMissingModule
+	case undefinedIdentifierRe.MatchString(msg):
+		return CauseUndefinedIdentifier
+	case strings.Contains(msg, "syntax error"):
+		return CauseSyntax
+	}
+	return CauseOther
+}
@@ -0,0 +1,37 @@
+package yaya_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/EFForg/yaya/pkg/yaya"
+)
+
+// Scanning in memory buffers, such as carved blobs or extracted attachments, from another Go program.
+// The rules are compiled once by NewScanner and the scanner is reused for every buffer.
+func ExampleScanner_ScanMem() {
+	store, err := yaya.NewStore(yaya.Options{ConfigDir: "/var/lib/yaya"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	scanner, err := yaya.NewScanner(store, yaya.ScannerOptions{Dedup: yaya.DedupFirst, Timeout: time.Minute})
+	if err != nil {
+		log.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+	matches, err := scanner.ScanMem(data)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, match := range matches {
+		fmt.Printf("[%s] %s\n", match.Ruleset, match.Rule)
+	}
+}
@@ -0,0 +1,176 @@
+package yaya
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/hillu/go-yara/v4"
+)
+
+// Rules returns the enabled rules of a ruleset that compile and aren't slow
+func (s *Store) Rules(ruleset *Ruleset) []Rule {
+	var rules []Rule
+	s.db.Model(ruleset).Where("enabled = ? AND broken = ? AND slow = ?", true, false, false).Related(&rules)
+	return rules
+}
+
+// ToggleRule enables a disabled rule or disables an enabled one
+func (s *Store) ToggleRule(rule *Rule) {
+	rule.Enabled = !rule.Enabled
+	s.db.Save(rule)
+}
+
+// BrokenRules returns the rules that failed to compile
+// Broken rules are revalidated on every update and come back once they compile
+func (s *Store) BrokenRules() []Rule {
+	var broken []Rule
+	s.db.Where("broken = ?", true).Preload("Ruleset").Order("ruleset_id, path").Find(&broken)
+	return broken
+}
+
+// SlowRules returns the rules scan -disable-slow left out because they slow down scanning
+// Slow rules are revalidated on every update and come back once yara stops warning about them
+func (s *Store) SlowRules() []Rule {
+	var slow []Rule
+	s.db.Where("slow = ?", true).Preload("Ruleset").Order("ruleset_id, path").Find(&slow)
+	return slow
+}
+
+// RuleWarnings returns the compiler warnings for enabled rules ordered by ruleset, path and line
+func (s *Store) RuleWarnings() []RuleWarning {
+	var warnings []RuleWarning
+	s.db.Preload("Rule").Preload("Rule.Ruleset").
+		Joins("JOIN rules ON rules.id = rule_warnings.rule_id").
+		Where("rules.enabled = ? AND rules.deleted_at IS NULL", true).
+		Order("rules.ruleset_id, rules.path, rule_warnings.line").Find(&warnings)
+	return warnings
+}
+
+// saveRuleWarnings replaces the stored compiler warnings for a rule
+func (s *Store) saveRuleWarnings(rule *Rule, warnings []yara.CompilerMessage) {
+	s.db.Unscoped().Where("rule_id = ?", rule.ID).Delete(&RuleWarning{})
+	for _, warning := range warnings {
+		s.db.Create(&RuleWarning{RuleID: rule.ID, Line: warning.Line, Text: warning.Text})
+	}
+}
+
+// rulesVersion fingerprints the set of rules a scan would use
+func (s *Store) rulesVersion(skip map[uint]bool) string {
+	var used []Rule
+	s.db.Select("rules.id, rules.fingerprint").Joins("JOIN rulesets ON rulesets.id = rules.ruleset_id").
+		Where("rulesets.enabled = ? AND rules.enabled = ? AND rules.broken = ? AND rules.slow = ?", true, true, false, false).
+		Order("rules.id").Find(&used)
+	h := sha256.New()
+	for _, rule := range used {
+		if !skip[rule.ID] {
+			fmt.Fprintf(h, "%d:%s\n", rule.ID, rule.Fingerprint)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// compileRuleset compiles the enabled rules of a ruleset, leaving out the rule IDs in skip
+func (s *Store) compileRuleset(ruleset *Ruleset, skip map[uint]bool, disableSlow bool) (*yara.Rules, error) {
+	c, err := yara.NewCompiler()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize YARA compiler: %s", err)
+	}
+
+	rules := s.Rules(ruleset)
+	s.log.Printf("Scanning with %s. Compiling %d rules\n", ruleset.Name, len(rules))
+	for _, rule := range rules {
+		if skip[rule.ID] {
+			continue
+		}
+		f, err := os.Open(rule.Path)
+		if err != nil {
+			s.log.Printf("Could not open rule file %s: %s\n", rule.Path, err)
+			break
+		}
+		warnings := len(c.Warnings)
+		err = c.AddFile(f, rule.Namespace)
+		f.Close()
+		if err != nil {
+			s.log.Printf("Could not parse rule file %s: %s", rule.Path, err)
+			break
+		}
+		if disableSlow && slowWarning(c.Warnings[warnings:]) {
+			s.log.Printf("Rule file %s is slowing down scanning, leaving it out of future scans", rule.Path)
+			s.db.Model(&rule).Update("slow", true)
+		}
+	}
+	return c.GetRules()
+}
+
+// slowWarning reports whether the compiler warned that a rule is slowing down scanning
+func slowWarning(warnings []yara.CompilerMessage) bool {
+	for _, warning := range warnings {
+		if strings.Contains(warning.Text, "slowing down scanning") {
+			return true
+		}
+	}
+	return false
+}
+
+// enabledRules calls fn for every enabled rule of every enabled ruleset, except the duplicates the dedup policy skips
+func (s *Store) enabledRules(dedup string, fn func(Rule) error) error {
+	skip := s.DuplicateRuleIDs(dedup)
+	var enabled []Ruleset
+	s.db.Where("enabled = ?", true).Find(&enabled)
+	for _, ruleset := range enabled {
+		for _, rule := range s.Rules(&ruleset) {
+			if skip[rule.ID] {
+				continue
+			}
+			if err := fn(rule); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ExportRules writes the source of every enabled rule to w
+func (s *Store) ExportRules(w io.Writer, dedup string) error {
+	return s.enabledRules(dedup, func(rule Rule) error {
+		dat, err := ioutil.ReadFile(rule.Path)
+		if err != nil {
+			return fmt.Errorf("could not open rule file %s: %s", rule.Path, err)
+		}
+		if _, err := w.Write(dat); err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, "\n")
+		return err
+	})
+}
+
+// CompileRules compiles every enabled rule into one set of rules
+func (s *Store) CompileRules(dedup string) (*yara.Rules, error) {
+	c, err := yara.NewCompiler()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize YARA compiler: %s", err)
+	}
+	err = s.enabledRules(dedup, func(rule Rule) error {
+		f, err := os.Open(rule.Path)
+		if err != nil {
+			s.log.Printf("Could not open rule file %s: %s\n", rule.Path, err)
+			return nil
+		}
+		err = c.AddFile(f, rule.Namespace)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("could not parse rule file %s: %s", rule.Path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.GetRules()
+}
@@ -0,0 +1,255 @@
+package yaya
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/hillu/go-yara/v4"
+)
+
+// RulesetManager clones and updates rulesets from git and keeps track of their rules
+type RulesetManager struct {
+	store *Store
+	dir   string
+	log   Logger
+}
+
+// NewRulesetManager manages the rulesets in store, cloning them into the store's rulesets directory
+func NewRulesetManager(store *Store) *RulesetManager {
+	return &RulesetManager{store: store, dir: store.opts.RulesetsDir, log: store.log}
+}
+
+// Path is where a ruleset is cloned
+func (m *RulesetManager) Path(ruleset *Ruleset) string {
+	return path.Join(m.dir, ruleset.Name)
+}
+
+// inDir reports whether p is inside the rulesets directory, so a ruleset can't be cloned to or removed from anywhere else
+func (m *RulesetManager) inDir(p string) bool {
+	rel, err := filepath.Rel(m.dir, p)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// checkRulesetName rejects names that aren't a single directory name, the name is where the ruleset is cloned
+func checkRulesetName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("bad ruleset name %q, it must be a directory name", name)
+	}
+	return nil
+}
+
+// Enabled returns the enabled rulesets
+func (m *RulesetManager) Enabled() []Ruleset {
+	var rulesets []Ruleset
+	m.store.db.Where("enabled = ?", true).Find(&rulesets)
+	return rulesets
+}
+
+// All returns every ruleset
+func (m *RulesetManager) All() []Ruleset {
+	var rulesets []Ruleset
+	m.store.db.Find(&rulesets)
+	return rulesets
+}
+
+// Count returns how many rulesets there are
+func (m *RulesetManager) Count() int {
+	var count int
+	m.store.db.Model(&Ruleset{}).Count(&count)
+	return count
+}
+
+// Toggle enables a disabled ruleset or disables an enabled one
+func (m *RulesetManager) Toggle(id uint) (*Ruleset, error) {
+	var ruleset Ruleset
+	if m.store.db.First(&ruleset, id).Error != nil {
+		return nil, fmt.Errorf("ruleset %d is not in the database", id)
+	}
+	ruleset.Enabled = !ruleset.Enabled
+	m.store.db.Save(&ruleset)
+	return &ruleset, nil
+}
+
+// InstallDefaults adds the DefaultRulesets that are missing and returns how many there are
+// Rulesets already there are left as they are, so one the user disabled stays disabled.
+func (m *RulesetManager) InstallDefaults() int {
+	for _, ruleset := range DefaultRulesets {
+		ruleset.Source = SourceAwesomeList
+		m.store.db.Where(Ruleset{Name: ruleset.Name}).FirstOrCreate(&ruleset)
+	}
+	return len(DefaultRulesets)
+}
+
+// Add adds a ruleset from a git repository, clones it and loads its rules
+func (m *RulesetManager) Add(url string) (*Ruleset, error) {
+	if match, _ := regexp.MatchString(`\.git$`, url); !match {
+		return nil, errors.New("not a git repository")
+	}
+
+	s := strings.TrimSuffix(url, ".git")
+	name := s[strings.LastIndex(s, "/")+1:]
+	if err := checkRulesetName(name); err != nil {
+		return nil, err
+	}
+	ruleset := Ruleset{Name: name, URL: url, Description: "Custom yara rules", Enabled: true}
+	m.log.Printf("creating ruleset %+v\n", ruleset)
+	if err := m.store.db.Create(&ruleset).Error; err != nil {
+		return nil, err
+	}
+	m.store.warn(m.Pull(&ruleset))
+	m.store.warn(m.LoadRules(&ruleset))
+	return &ruleset, nil
+}
+
+// Update pulls every enabled ruleset and loads their new rules
+func (m *RulesetManager) Update() {
+	m.log.Printf("Updating YARA Rules...")
+	for _, ruleset := range m.Enabled() {
+		m.store.warn(m.Pull(&ruleset))
+	}
+	for _, ruleset := range m.Enabled() {
+		m.store.warn(m.LoadRules(&ruleset))
+	}
+}
+
+// Pull clones a ruleset, or pulls it if it was already cloned
+func (m *RulesetManager) Pull(ruleset *Ruleset) error {
+	rulesetPath := m.Path(ruleset)
+	if !m.inDir(rulesetPath) {
+		return fmt.Errorf("ruleset %q is outside %s, not cloning it", ruleset.Name, m.dir)
+	}
+	pathExists, _ := exists(rulesetPath)
+	if !pathExists {
+		m.log.Printf("git clone %q", ruleset.URL)
+		_, err := git.PlainClone(rulesetPath, false, &git.CloneOptions{
+			URL: ruleset.URL,
+		})
+		return err
+	}
+
+	m.log.Printf("git pull %s", ruleset.Name)
+	// We instantiate a new repository targeting the given path (the .git folder)
+	r, err := git.PlainOpen(rulesetPath)
+	if err != nil {
+		return err
+	}
+	// Get the working directory for the repository
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	// Pull the latest changes from the origin remote and merge into the current branch
+	err = w.Pull(&git.PullOptions{RemoteName: "origin"})
+	if err != nil && !(strings.Contains(err.Error(), "already")) {
+		return err
+	}
+	return nil
+}
+
+// Commit returns the git commit a ruleset is checked out at
+func (m *RulesetManager) Commit(ruleset *Ruleset) string {
+	r, err := git.PlainOpen(m.Path(ruleset))
+	if err != nil {
+		return "unknown"
+	}
+	head, err := r.Head()
+	if err != nil {
+		return "unknown"
+	}
+	return head.Hash().String()
+}
+
+// Versions lists the enabled rulesets with the git commit they are at
+func (m *RulesetManager) Versions() []string {
+	var enabled []Ruleset
+	m.store.db.Where("enabled = ?", true).Order("name").Find(&enabled)
+	var versions []string
+	for _, ruleset := range enabled {
+		versions = append(versions, fmt.Sprintf("%s %s", ruleset.Name, m.Commit(&ruleset)))
+	}
+	return versions
+}
+
+// LoadRules finds the rule files of a ruleset, fingerprints them and validates them
+// Rulesets that were never cloned are disabled.
+func (m *RulesetManager) LoadRules(ruleset *Ruleset) error {
+	if !ruleset.Enabled {
+		return nil
+	}
+	db := m.store.db
+	rulesetPath := m.Path(ruleset)
+	m.log.Printf("loading %q located at %s", ruleset.Name, rulesetPath)
+	if pathExists, _ := exists(rulesetPath); !pathExists {
+		ruleset.Enabled = false
+		db.Save(ruleset)
+		return fmt.Errorf("the ruleset path %s doesn't exist, disabling for now", rulesetPath)
+	}
+
+	// scan ruleset path for *.yar[a]?$
+	err := filepath.Walk(rulesetPath, func(path string, info os.FileInfo, e error) error {
+		if e != nil {
+			return e
+		}
+
+		// check if it is a regular file (not dir)
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if matched, _ := filepath.Match(`*.yar*`, info.Name()); !matched {
+			return nil
+		}
+		// create struct Rule and append to ruleset
+		r := Rule{Path: path, RulesetID: ruleset.ID}
+		record := db.FirstOrCreate(&r, r)
+		rulename := strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
+		r.Namespace = fmt.Sprintf("%s:%s-%d", ruleset.Name, rulename, r.ID)
+		if body, err := ioutil.ReadFile(r.Path); err == nil {
+			r.Fingerprint = fingerprintRule(body)
+		}
+		db.Save(&r)
+		// validate yara rule, broken rules are retried so upstream fixes are picked up
+		if r.Enabled {
+			m.validate(&r)
+		}
+		db.Model(ruleset).Association("Rules").Append(record)
+		return nil
+	})
+	// DB update ruleset
+	db.Save(ruleset)
+	return err
+}
+
+// validate compiles a rule on its own, marking it broken if it doesn't compile, recording compiler warnings and clearing Slow once yara stops warning
+func (m *RulesetManager) validate(r *Rule) {
+	db := m.store.db
+	c, _ := yara.NewCompiler()
+	f, err := os.Open(r.Path)
+	if err != nil {
+		m.log.Printf("Could not open rule file %s: %s\n", r.Path, err)
+		return
+	}
+	err = c.AddFile(f, r.Namespace)
+	f.Close()
+	m.store.saveRuleWarnings(r, c.Warnings)
+	if err != nil {
+		m.log.Printf("Could not parse rule file %s: %s", r.Path, err)
+		r.setCompileError(err)
+		db.Save(r)
+	} else if r.Broken {
+		m.log.Printf("Rule file %s compiles again, re-enabling", r.Path)
+		r.clearCompileError()
+		db.Save(r)
+	}
+	if err == nil && r.Slow && !slowWarning(c.Warnings) {
+		m.log.Printf("Rule file %s no longer slows down scanning, re-enabling", r.Path)
+		r.Slow = false
+		db.Model(r).Update("slow", false)
+	}
+}
@@ -0,0 +1,151 @@
+package yaya
+
+import (
+	"time"
+
+	"github.com/hillu/go-yara/v4"
+)
+
+// ScannerOptions control how a Scanner compiles rules and scans
+type ScannerOptions struct {
+	// Dedup is a dedup policy, see ValidDedupPolicy
+	Dedup string
+	// DisableSlow marks rules that yara warns are slowing down scanning as slow, leaving them out of future scans
+	DisableSlow bool
+	// Timeout is how long to scan a single target with one ruleset, 0 for no limit
+	Timeout time.Duration
+}
+
+// RulesetScanner is a compiled ruleset and the time spent with it
+type RulesetScanner struct {
+	Name    string
+	Rules   int
+	Scanner *yara.Scanner
+	Compile time.Duration
+	Scan    time.Duration
+	rules   *yara.Rules
+}
+
+// Scanner scans with every enabled ruleset, each one compiled separately so matches can be traced back to it
+// A Scanner is not safe for concurrent use.
+type Scanner struct {
+	Rulesets []*RulesetScanner
+	version  string
+	timeout  time.Duration
+}
+
+// Match is a rule match and the ruleset it came from
+type Match struct {
+	Ruleset string
+	yara.MatchRule
+}
+
+// NewScanner compiles the enabled rules in store
+func NewScanner(store *Store, opts ScannerOptions) (*Scanner, error) {
+	skip := store.DuplicateRuleIDs(opts.Dedup)
+	var enabled []Ruleset
+	store.db.Where("enabled = ?", true).Find(&enabled)
+
+	s := &Scanner{timeout: opts.Timeout}
+	for _, ruleset := range enabled {
+		compileStart := time.Now()
+		rules, err := store.compileRuleset(&ruleset, skip, opts.DisableSlow)
+		if err != nil {
+			return nil, err
+		}
+		scanner, err := yara.NewScanner(rules)
+		if err != nil {
+			return nil, err
+		}
+		scanner.SetTimeout(opts.Timeout)
+		s.Rulesets = append(s.Rulesets, &RulesetScanner{
+			Name:    ruleset.Name,
+			Rules:   len(rules.GetRules()),
+			Scanner: scanner,
+			Compile: time.Since(compileStart),
+			rules:   rules,
+		})
+	}
+	// after compiling, -disable-slow may have marked rules slow
+	s.version = store.rulesVersion(skip)
+	return s, nil
+}
+
+// Clone returns a scanner sharing the compiled rules, give each goroutine its own clone to scan concurrently
+func (s *Scanner) Clone() (*Scanner, error) {
+	clone := &Scanner{version: s.version, timeout: s.timeout}
+	for _, rs := range s.Rulesets {
+		scanner, err := yara.NewScanner(rs.rules)
+		if err != nil {
+			return nil, err
+		}
+		scanner.SetTimeout(s.timeout)
+		clone.Rulesets = append(clone.Rulesets, &RulesetScanner{
+			Name:    rs.Name,
+			Rules:   rs.Rules,
+			Scanner: scanner,
+			Compile: rs.Compile,
+			rules:   rs.rules,
+		})
+	}
+	return clone, nil
+}
+
+// RulesVersion fingerprints the rules the scanner was compiled from
+func (s *Scanner) RulesVersion() string {
+	return s.version
+}
+
+// ScanMem scans a buffer with every ruleset
+// The rules were compiled by NewScanner, so one scanner can scan any number of buffers without recompiling.
+func (s *Scanner) ScanMem(data []byte) ([]Match, error) {
+	return s.scan(func(scanner *yara.Scanner) error { return scanner.ScanMem(data) })
+}
+
+// ScanFile scans a file with every ruleset
+func (s *Scanner) ScanFile(path string) ([]Match, error) {
+	return s.scan(func(scanner *yara.Scanner) error { return scanner.ScanFile(path) })
+}
+
+// ScanProc scans the memory of a process with every ruleset
+func (s *Scanner) ScanProc(pid int) ([]Match, error) {
+	return s.scan(func(scanner *yara.Scanner) error { return scanner.ScanProc(pid) })
+}
+
+// RulesetResult is what scanning a target with one ruleset found
+type RulesetResult struct {
+	Ruleset string
+	Matches yara.MatchRules
+	// Err is why the ruleset didn't finish scanning the target, such as a timeout, Matches has what it found before that
+	Err error
+}
+
+// Scan scans a target with one ruleset after another, timing each one
+// scan runs a ruleset's scanner over the target, e.g. by calling its ScanFile. each is called with what
+// every ruleset found, and decides how to handle errors: scanning stops when it returns false.
+func (s *Scanner) Scan(scan func(*yara.Scanner) error, each func(RulesetResult) bool) {
+	for _, rs := range s.Rulesets {
+		var results yara.MatchRules
+		rs.Scanner.SetCallback(&results)
+		start := time.Now()
+		err := scan(rs.Scanner)
+		rs.Scan += time.Since(start)
+		if !each(RulesetResult{Ruleset: rs.Name, Matches: results, Err: err}) {
+			return
+		}
+	}
+}
+
+// scan stops at the first ruleset that fails
+func (s *Scanner) scan(fn func(*yara.Scanner) error) ([]Match, error) {
+	var matches []Match
+	var err error
+	s.Scan(fn, func(result RulesetResult) bool {
+		for _, match := range result.Matches {
+			matches = append(matches, Match{Ruleset: result.Ruleset, MatchRule: match})
+		}
+		err = result.Err
+		return err == nil
+	})
+	return matches, err
+}
@@ -0,0 +1,189 @@
+package yaya
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	// yaya keeps its state in SQLite
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// Ruleset is a record for a yara ruleset
+type Ruleset struct {
+	gorm.Model
+	Name        string `gorm:"unique_index"`
+	URL         string
+	Description string
+	Enabled     bool `gorm:"default:true"`
+	// Source is where the ruleset came from, SourceAwesomeList or empty for rulesets added by hand
+	Source string
+	Rules  []Rule
+}
+
+// Status is enabled or disabled
+func (ruleset *Ruleset) Status() string {
+	if ruleset.Enabled {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+// Rule is an individual YARA rule
+type Rule struct {
+	gorm.Model
+	Namespace string
+	Path      string
+	Enabled   bool `gorm:"default:true"`
+	Broken    bool
+	// Slow is set by scan -disable-slow when yara warns the rule is slowing down scanning, it is separate from Enabled which is the user's choice
+	Slow         bool
+	Fingerprint  string `gorm:"index"`
+	CompileError string
+	ErrorModule  string
+	ErrorAt      *time.Time
+	Ruleset      Ruleset
+	RulesetID    uint
+}
+
+// setCompileError marks the rule broken, recording why it failed to compile and which yara module it needed
+func (rule *Rule) setCompileError(err error) {
+	now := time.Now()
+	rule.Broken = true
+	rule.CompileError = err.Error()
+	rule.ErrorModule = errorModule(rule.CompileError)
+	rule.ErrorAt = &now
+}
+
+// clearCompileError marks the rule as compiling again
+func (rule *Rule) clearCompileError() {
+	rule.Broken = false
+	rule.CompileError = ""
+	rule.ErrorModule = ""
+	rule.ErrorAt = nil
+}
+
+// RuleWarning is a warning the yara compiler gave for a rule file
+type RuleWarning struct {
+	gorm.Model
+	RuleID uint `gorm:"index"`
+	Rule   Rule
+	Line   int
+	Text   string
+}
+
+// Setting is a value yaya keeps about itself, such as whether it was initialized
+type Setting struct {
+	gorm.Model
+	Key   string `gorm:"unique_index"`
+	Value string
+}
+
+// settingInitialized is set once yaya has been initialized with rulesets
+const settingInitialized = "initialized"
+
+// Store keeps rulesets, rules and what yaya learned about them in a SQLite database
+// It holds no other state, so it is safe for concurrent use.
+type Store struct {
+	db   *gorm.DB
+	opts Options
+	log  Logger
+	// ownDB is set when the store opened the database and closes it
+	ownDB bool
+}
+
+// NewStore opens the database at opts.DBPath, creating it and the config directories if needed
+func NewStore(opts Options) (*Store, error) {
+	opts = opts.withDefaults()
+	if err := os.MkdirAll(path.Dir(opts.DBPath), os.ModePerm); err != nil {
+		return nil, err
+	}
+	db, err := gorm.Open("sqlite3", opts.DBPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect database: %s", err)
+	}
+	// SQLite allows one writer at a time, sharing one connection keeps concurrent writes from failing with "database is locked"
+	db.DB().SetMaxOpenConns(1)
+	store, err := NewStoreWithDB(db, opts)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	store.ownDB = true
+	return store, nil
+}
+
+// NewStoreWithDB keeps the rules in an already open database, such as a temporary one in tests
+// opts.DBPath is ignored and the caller stays responsible for closing db.
+func NewStoreWithDB(db *gorm.DB, opts Options) (*Store, error) {
+	opts = opts.withDefaults()
+	if err := os.MkdirAll(opts.RulesetsDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	store := &Store{db: db, opts: opts, log: opts.Logger}
+	if err := store.migrate(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// migrate brings the schema of the rule store up to date
+func (s *Store) migrate() error {
+	hadBroken := s.db.Dialect().HasColumn("rules", "broken")
+	hadSettings := s.db.HasTable(&Setting{})
+	if err := s.db.AutoMigrate(&Rule{}, &Ruleset{}, &RuleWarning{}, &Setting{}).Error; err != nil {
+		return fmt.Errorf("failed to migrate the database: %s", err)
+	}
+	if !hadBroken {
+		// Rules used to be disabled when they failed to compile, mark them broken instead so they get revalidated
+		s.db.Model(&Rule{}).Where("enabled = ?", false).Updates(map[string]interface{}{"enabled": true, "broken": true})
+	}
+	if !hadSettings {
+		// databases from before the initialized setting were initialized if they have rulesets
+		var count int
+		s.db.Model(&Ruleset{}).Count(&count)
+		if count > 0 {
+			s.MarkInitialized()
+		}
+	}
+	return nil
+}
+
+// Initialized reports whether yaya was initialized, see MarkInitialized
+func (s *Store) Initialized() bool {
+	var setting Setting
+	return !s.db.Where("key = ?", settingInitialized).First(&setting).RecordNotFound()
+}
+
+// MarkInitialized records that yaya was initialized, so removing every ruleset doesn't bring the defaults back
+func (s *Store) MarkInitialized() {
+	setting := Setting{Key: settingInitialized}
+	s.db.Where(setting).Assign(Setting{Value: time.Now().Format(time.RFC3339)}).FirstOrCreate(&setting)
+}
+
+// DB is the store's database, for keeping records alongside the rules
+func (s *Store) DB() *gorm.DB {
+	return s.db
+}
+
+// Options are the options the store was opened with, with defaults filled in
+func (s *Store) Options() Options {
+	return s.opts
+}
+
+// Close closes the database if the store opened it
+func (s *Store) Close() error {
+	if !s.ownDB {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// warn logs a non fatal error
+func (s *Store) warn(err error) {
+	if err != nil {
+		s.log.Printf("[!] WARNING: %s", err)
+	}
+}
@@ -0,0 +1,213 @@
+package yaya
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+// newTestStore opens a store on a temporary SQLite database, setup runs on the database before the store migrates it
+// The returned func closes and removes the database.
+func newTestStore(t *testing.T, setup func(db *gorm.DB)) (*Store, func()) {
+	dir, err := ioutil.TempDir("", "yaya-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open("sqlite3", filepath.Join(dir, "yaya.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+	if setup != nil {
+		setup(db)
+	}
+	store, err := NewStoreWithDB(db, Options{ConfigDir: dir, Logger: log.New(ioutil.Discard, "", 0)})
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return store, cleanup
+}
+
+func TestMigrateBrokenRules(t *testing.T) {
+	store, cleanup := newTestStore(t, func(db *gorm.DB) {
+		// rules failing to compile used to be disabled, before the broken column
+		db.Exec("CREATE TABLE rules (id integer primary key autoincrement, created_at datetime, updated_at datetime, deleted_at datetime, namespace varchar(255), path varchar(255), enabled bool default true, ruleset_id integer)")
+		db.Exec("INSERT INTO rules (path, enabled, ruleset_id) VALUES ('ok.yar', 1, 1), ('failed.yar', 0, 1)")
+	})
+	defer cleanup()
+	var rules []Rule
+	store.db.Order("id").Find(&rules)
+	if len(rules) != 2 {
+		t.Fatalf("found %d rules, want 2", len(rules))
+	}
+	if !rules[0].Enabled || rules[0].Broken {
+		t.Errorf("%s: enabled %v broken %v, want enabled and not broken", rules[0].Path, rules[0].Enabled, rules[0].Broken)
+	}
+	if !rules[1].Enabled || !rules[1].Broken {
+		t.Errorf("%s: enabled %v broken %v, want enabled and broken so it is revalidated", rules[1].Path, rules[1].Enabled, rules[1].Broken)
+	}
+}
+
+func TestInitialized(t *testing.T) {
+	store, cleanup := newTestStore(t, nil)
+	defer cleanup()
+	if store.Initialized() {
+		t.Fatal("a new store is initialized")
+	}
+	store.MarkInitialized()
+	store.MarkInitialized()
+	if !store.Initialized() {
+		t.Fatal("the store isn't initialized after MarkInitialized")
+	}
+	var count int
+	store.db.Model(&Setting{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d settings, want 1", count)
+	}
+}
+
+func TestInitializedFromOldDatabase(t *testing.T) {
+	store, cleanup := newTestStore(t, func(db *gorm.DB) {
+		// databases from before the initialized setting
+		db.AutoMigrate(&Ruleset{})
+		db.Create(&Ruleset{Name: "old", URL: "https://github.com/owner/old.git"})
+	})
+	defer cleanup()
+	if !store.Initialized() {
+		t.Error("a database with rulesets from before the initialized setting isn't initialized")
+	}
+}
+
+func TestDuplicateRules(t *testing.T) {
+	store, cleanup := newTestStore(t, nil)
+	defer cleanup()
+	rulesets := []Ruleset{{Name: "a", Enabled: true}, {Name: "b", Enabled: true}, {Name: "c", Enabled: true}}
+	for i := range rulesets {
+		store.db.Create(&rulesets[i])
+	}
+	evil := fingerprintRule([]byte(`rule a { strings: $s = "http://evil.example/one" condition: $s }`))
+	good := fingerprintRule([]byte(`rule a { strings: $s = "http://good.example/two" condition: $s }`))
+	rules := []Rule{
+		{Path: "a/evil.yar", RulesetID: rulesets[0].ID, Fingerprint: evil},
+		{Path: "b/evil.yar", RulesetID: rulesets[1].ID, Fingerprint: evil},
+		{Path: "c/evil.yar", RulesetID: rulesets[2].ID, Fingerprint: evil},
+		{Path: "a/good.yar", RulesetID: rulesets[0].ID, Fingerprint: good},
+		{Path: "b/broken.yar", RulesetID: rulesets[1].ID, Fingerprint: good, Broken: true},
+	}
+	for i := range rules {
+		store.db.Create(&rules[i])
+	}
+
+	groups := store.DuplicateRules()
+	if len(groups) != 1 || len(groups[evil]) != 3 {
+		t.Fatalf("DuplicateRules = %v, want the three copies of evil.yar", groups)
+	}
+
+	tests := []struct {
+		policy string
+		skip   []uint
+	}{
+		{policy: DedupNone},
+		{policy: DedupFirst, skip: []uint{rules[1].ID, rules[2].ID}},
+		{policy: DedupPrefer + "b", skip: []uint{rules[0].ID, rules[2].ID}},
+		{policy: DedupPrefer + "missing", skip: []uint{rules[1].ID, rules[2].ID}},
+	}
+	for _, test := range tests {
+		skip := store.DuplicateRuleIDs(test.policy)
+		if len(skip) != len(test.skip) {
+			t.Errorf("DuplicateRuleIDs(%q) = %v, want %v", test.policy, skip, test.skip)
+			continue
+		}
+		for _, id := range test.skip {
+			if !skip[id] {
+				t.Errorf("DuplicateRuleIDs(%q) = %v, want %v", test.policy, skip, test.skip)
+			}
+		}
+	}
+}
+
+func TestImportCatalog(t *testing.T) {
+	store, cleanup := newTestStore(t, nil)
+	defer cleanup()
+	m := NewRulesetManager(store)
+	catalog, err := ReadCatalog(strings.NewReader(`{"version": 1, "rulesets": [
+		{"name": "one", "url": "https://github.com/owner/one.git", "enabled": true, "disabled_rules": ["rules/noisy.yar"]},
+		{"name": "two", "url": "https://github.com/owner/two.git", "enabled": false}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := m.ImportCatalog(catalog)
+	if want := (CatalogChanges{Added: 2, DisabledRules: 1}); changes != want {
+		t.Errorf("first import = %+v, want %+v", changes, want)
+	}
+	changes = m.ImportCatalog(catalog)
+	if want := (CatalogChanges{Unchanged: 2}); changes != want {
+		t.Errorf("second import = %+v, want %+v", changes, want)
+	}
+
+	exported := m.ExportCatalog()
+	if len(exported.Rulesets) != 2 || exported.Rulesets[1].Enabled ||
+		len(exported.Rulesets[0].DisabledRules) != 1 || exported.Rulesets[0].DisabledRules[0] != "rules/noisy.yar" {
+		t.Errorf("ExportCatalog = %+v, want the imported catalog", exported.Rulesets)
+	}
+}
+
+func TestImportCatalogMovedRuleset(t *testing.T) {
+	store, cleanup := newTestStore(t, nil)
+	defer cleanup()
+	m := NewRulesetManager(store)
+	catalog := &Catalog{Version: catalogVersion, Rulesets: []CatalogRuleset{{Name: "one", URL: "https://github.com/owner/one.git", Enabled: true}}}
+	m.ImportCatalog(catalog)
+	clone := m.Path(&Ruleset{Name: "one"})
+	if err := os.MkdirAll(clone, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	catalog.Rulesets[0].URL = "https://github.com/other/one.git"
+	if changes := m.ImportCatalog(catalog); changes.Updated != 1 || changes.Recloned != 1 {
+		t.Errorf("import = %+v, want one ruleset updated and recloned", changes)
+	}
+	if found, _ := exists(clone); found {
+		t.Error("the clone of the moved ruleset is still there")
+	}
+}
+
+func TestReadCatalogPaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		ruleset string
+		ok      bool
+	}{
+		{name: "plain", ruleset: `{"name": "rules", "url": "a", "disabled_rules": ["dir/rule.yar"]}`, ok: true},
+		{name: "spaces", ruleset: `{"name": "CDI Rules", "url": "a"}`, ok: true},
+		{name: "no name", ruleset: `{"name": "", "url": "a"}`},
+		{name: "dot", ruleset: `{"name": ".", "url": "a"}`},
+		{name: "dot dot", ruleset: `{"name": "..", "url": "a"}`},
+		{name: "parent", ruleset: `{"name": "../../victim", "url": "a"}`},
+		{name: "separator", ruleset: `{"name": "owner/rules", "url": "a"}`},
+		{name: "backslash", ruleset: `{"name": "..\\victim", "url": "a"}`},
+		{name: "absolute rule", ruleset: `{"name": "rules", "url": "a", "disabled_rules": ["/etc/passwd"]}`},
+		{name: "rule outside", ruleset: `{"name": "rules", "url": "a", "disabled_rules": ["dir/../../other/rule.yar"]}`},
+		{name: "rule inside after cleaning", ruleset: `{"name": "rules", "url": "a", "disabled_rules": ["dir/../rule.yar"]}`, ok: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ReadCatalog(strings.NewReader(`{"version": 1, "rulesets": [` + test.ruleset + `]}`))
+			if (err == nil) != test.ok {
+				t.Errorf("ReadCatalog error = %v, want ok %v", err, test.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+// Package yaya curates open source yara rules and scans with them.
+//
+// A Store keeps rulesets and rules in a SQLite database, a RulesetManager clones
+// and updates rulesets from git, and a Scanner compiles the enabled rules and
+// scans files, buffers and processes with them. The yaya command is a thin
+// wrapper over this package.
+package yaya
+
+import (
+	"log"
+	"os"
+	"path"
+)
+
+// Logger is where yaya reports progress and non fatal errors, *log.Logger satisfies it
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Options say where yaya keeps its state and where it logs, empty fields get defaults
+type Options struct {
+	// ConfigDir defaults to ~/.yaya
+	ConfigDir string
+	// DBPath defaults to yaya.db in ConfigDir
+	DBPath string
+	// RulesetsDir is where rulesets are cloned, it defaults to rulsets in ConfigDir
+	RulesetsDir string
+	// Logger defaults to logging to stderr
+	Logger Logger
+}
+
+// DefaultConfigDir is ~/.yaya
+func DefaultConfigDir() string {
+	home, _ := os.UserHomeDir()
+	return path.Join(home, ".yaya")
+}
+
+func (opts Options) withDefaults() Options {
+	if opts.ConfigDir == "" {
+		opts.ConfigDir = DefaultConfigDir()
+	}
+	if opts.DBPath == "" {
+		opts.DBPath = path.Join(opts.ConfigDir, "yaya.db")
+	}
+	if opts.RulesetsDir == "" {
+		opts.RulesetsDir = path.Join(opts.ConfigDir, "rulsets")
+	}
+	if opts.Logger == nil {
+		opts.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	return opts
+}
+
+// exists returns whether a given path exists
+func exists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
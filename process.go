@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// procPath is where process information is read from, this only exists on Linux
+const procPath = "/proc"
+
+// listProcesses returns the pids of all running processes except yaya itself
+func listProcesses() ([]int, error) {
+	entries, err := ioutil.ReadDir(procPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not list processes: %s", err)
+	}
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() || pid == os.Getpid() {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// processLabel describes a process by pid, name and command line for scan results
+func processLabel(pid int) string {
+	label := fmt.Sprintf("pid:%d", pid)
+	if comm, err := ioutil.ReadFile(path.Join(procPath, strconv.Itoa(pid), "comm")); err == nil {
+		label += fmt.Sprintf(" [%s]", strings.TrimSpace(string(comm)))
+	}
+	if cmdline, err := ioutil.ReadFile(path.Join(procPath, strconv.Itoa(pid), "cmdline")); err == nil && len(cmdline) > 0 {
+		label += " " + strings.TrimSpace(strings.Replace(string(cmdline), "\x00", " ", -1))
+	}
+	return label
+}
+
+// isAttachError reports whether a process scan failed because yaya may not read the process memory
+func isAttachError(err error) bool {
+	return strings.Contains(err.Error(), "could not attach to process") ||
+		strings.Contains(err.Error(), "could not read process memory")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/hillu/go-yara/v4"
+)
+
+// How many entries the profile report shows
+const profileTop = 20
+
+// scanProfile collects timings for a `scan -profile` run
+type scanProfile struct {
+	rulesets  []rulesetProfile
+	ruleCosts map[string]uint64
+}
+
+// rulesetProfile is the time spent compiling and scanning with one ruleset
+type rulesetProfile struct {
+	name    string
+	rules   int
+	compile time.Duration
+	scan    time.Duration
+}
+
+func newScanProfile() *scanProfile {
+	return &scanProfile{ruleCosts: map[string]uint64{}}
+}
+
+func (p *scanProfile) addRuleset(name string, rules int, compile, scan time.Duration) {
+	p.rulesets = append(p.rulesets, rulesetProfile{name: name, rules: rules, compile: compile, scan: scan})
+}
+
+// addRuleCosts records per rule costs, they are only reported when libyara was built with --enable-profiling
+func (p *scanProfile) addRuleCosts(scanner *yara.Scanner) {
+	for _, info := range scanner.GetProfilingInfo() {
+		p.ruleCosts[fmt.Sprintf("[%s] %s", info.Namespace(), info.Identifier())] += info.Cost
+	}
+}
+
+// print shows the slowest rulesets and rules
+func (p *scanProfile) print(w io.Writer) {
+	sort.Slice(p.rulesets, func(i, j int) bool {
+		return p.rulesets[i].compile+p.rulesets[i].scan > p.rulesets[j].compile+p.rulesets[j].scan
+	})
+	fmt.Fprintln(w, "Slowest rulesets:")
+	fmt.Fprintf(w, "%12s %12s %6s\t%s\n", "Scan", "Compile", "Rules", "Name")
+	for i, ruleset := range p.rulesets {
+		if i == profileTop {
+			break
+		}
+		fmt.Fprintf(w, "%12s %12s %6d\t%s\n", ruleset.scan.Round(time.Millisecond), ruleset.compile.Round(time.Millisecond), ruleset.rules, ruleset.name)
+	}
+
+	if len(p.ruleCosts) == 0 {
+		fmt.Fprintln(w, "\nPer rule costs are not available, build libyara with --enable-profiling to get them.")
+		return
+	}
+	var names []string
+	for name := range p.ruleCosts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return p.ruleCosts[names[i]] > p.ruleCosts[names[j]]
+	})
+	fmt.Fprintln(w, "\nSlowest rules:")
+	fmt.Fprintf(w, "%12s\t%s\n", "Cost", "Rule")
+	for i, name := range names {
+		if i == profileTop {
+			break
+		}
+		fmt.Fprintf(w, "%12d\t%s\n", p.ruleCosts[name], name)
+	}
+}
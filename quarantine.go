@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// QuarantinedFile is a matched file that was moved or copied into a quarantine directory
+type QuarantinedFile struct {
+	gorm.Model
+	ScanID       uint
+	OriginalPath string
+	// LinkPath is the symlink the file was scanned through, OriginalPath is the file it points to
+	LinkPath   string
+	StoredPath string
+	Mode       uint32
+	Size       int64
+	MD5        string
+	SHA1       string
+	SHA256     string
+	Rules      string
+	Copied     bool
+	RestoredAt *time.Time
+}
+
+// copyFile copies src to dst, creating dst with the given permissions
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// moveFile renames src to dst, copying when they are on different filesystems
+func moveFile(src, dst string, mode os.FileMode) error {
+	if err := os.Rename(src, dst); err == nil {
+		return os.Chmod(dst, mode)
+	}
+	if err := copyFile(src, dst, mode); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// quarantineFile moves or copies a matched file into dir and records it, along with the rules that matched it and memberRules, the rules that matched its archive members
+// A symlink is followed and the file it points to is quarantined, not the link.
+// Stored files are only readable by their owner so they can't be run by accident.
+func quarantineFile(db *gorm.DB, dir string, scanID uint, result *scanResult, memberRules []string, copy bool) {
+	path, err := filepath.EvalSymlinks(result.Path)
+	if err != nil {
+		Warning(fmt.Errorf("could not quarantine %s: %s", result.Path, err))
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		Warning(fmt.Errorf("could not quarantine %s: %s", result.Path, err))
+		return
+	}
+	os.MkdirAll(dir, 0700)
+
+	var rules []string
+	for _, match := range result.Matches {
+		rules = append(rules, fmt.Sprintf("[%s] %s", match.Namespace, match.Rule))
+	}
+	rules = append(rules, memberRules...)
+	q := QuarantinedFile{
+		ScanID:       scanID,
+		OriginalPath: path,
+		Mode:         uint32(info.Mode().Perm()),
+		Size:         info.Size(),
+		Rules:        strings.Join(rules, "\n"),
+		Copied:       copy,
+	}
+	if path != result.Path {
+		q.LinkPath = result.Path
+	}
+	file := result.File
+	if file == nil {
+		// archives matched through their members weren't hashed
+		file, _ = hashFile(path)
+	}
+	if file != nil {
+		q.MD5, q.SHA1, q.SHA256 = file.MD5, file.SHA1, file.SHA256
+	}
+	db.Create(&q)
+
+	q.StoredPath = filepath.Join(dir, fmt.Sprintf("%d-%s", q.ID, filepath.Base(path)))
+	if copy {
+		err = copyFile(path, q.StoredPath, 0400)
+	} else {
+		err = moveFile(path, q.StoredPath, 0400)
+	}
+	if err != nil {
+		Warning(fmt.Errorf("could not quarantine %s: %s", path, err))
+		db.Unscoped().Delete(&q)
+		return
+	}
+	db.Save(&q)
+	if q.LinkPath != "" {
+		log.Printf("Quarantined %s, linked from %s, as %s", path, q.LinkPath, q.StoredPath)
+		return
+	}
+	log.Printf("Quarantined %s as %s", path, q.StoredPath)
+}
+
+// quarantineCommand handles `quarantine list|restore <id>|purge <id>`
+func quarantineCommand(db *gorm.DB, action, arg string) {
+	if action != "list" && action != "restore" && action != "purge" {
+		log.Fatalln("You must specify a quarantine action (list, restore, purge).")
+	}
+
+	if action == "list" {
+		var files []QuarantinedFile
+		db.Where("restored_at IS NULL").Order("id").Find(&files)
+		fmt.Printf("%4s %-19s %-64s\t%s\n", "ID", "Quarantined", "SHA256", "Original path")
+		for _, q := range files {
+			fmt.Printf("%4d %-19s %-64s\t%s\n", q.ID, q.CreatedAt.Format("2006-01-02 15:04:05"), q.SHA256, q.OriginalPath)
+			if q.LinkPath != "" {
+				fmt.Printf("       linked from %s\n", q.LinkPath)
+			}
+			for _, rule := range strings.Split(q.Rules, "\n") {
+				fmt.Printf("       - %s\n", rule)
+			}
+		}
+		return
+	}
+
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		log.Fatalf("You must specify the ID of a quarantined file to %s.", action)
+	}
+	var q QuarantinedFile
+	if db.Where("restored_at IS NULL").First(&q, id).Error != nil {
+		log.Fatalf("File %d is not in quarantine", id)
+	}
+
+	switch action {
+	case "restore":
+		if exists, _ := Exists(q.OriginalPath); exists {
+			log.Fatalf("Not restoring %d, %s already exists", q.ID, q.OriginalPath)
+		}
+		os.MkdirAll(filepath.Dir(q.OriginalPath), os.ModePerm)
+		if err := moveFile(q.StoredPath, q.OriginalPath, os.FileMode(q.Mode)); err != nil {
+			log.Fatalf("Could not restore %s: %s", q.OriginalPath, err)
+		}
+		now := time.Now()
+		q.RestoredAt = &now
+		db.Save(&q)
+		fmt.Printf("Restored %s\n", q.OriginalPath)
+	case "purge":
+		if err := os.Remove(q.StoredPath); err != nil && !os.IsNotExist(err) {
+			log.Fatalf("Could not purge %s: %s", q.StoredPath, err)
+		}
+		db.Delete(&q)
+		fmt.Printf("Purged %s\n", q.StoredPath)
+	}
+}
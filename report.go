@@ -0,0 +1,167 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const defaultReportPath = "/tmp/yaya-report.html"
+
+// reportData is everything the HTML report shows
+type reportData struct {
+	Source     string
+	Generated  time.Time
+	Scan       *Scan
+	Rulesets   []string
+	Files      int
+	Matches    int
+	Suppressed int
+	Matched    []*scanResult
+	Failed     []*scanResult
+}
+
+// references returns the meta values of a match that look like links
+func references(match resultMatch) []string {
+	var refs []string
+	for _, meta := range match.Meta {
+		value, ok := meta.Value.(string)
+		if !ok {
+			continue
+		}
+		id := strings.ToLower(meta.Identifier)
+		if strings.HasPrefix(id, "ref") || id == "url" || id == "link" ||
+			strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") {
+			refs = append(refs, value)
+		}
+	}
+	return refs
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"references": references,
+	"isLink": func(s string) bool {
+		return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>YAYA scan report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { margin-bottom: 0; }
+.muted { color: #777; }
+.summary td { padding: 0.2em 1em 0.2em 0; }
+.file { border: 1px solid #ccc; border-radius: 4px; margin: 1em 0; padding: 0.5em 1em; }
+.score { float: right; font-weight: bold; padding: 0.2em 0.6em; border-radius: 4px; background: #eee; }
+.high { background: #f4b6b6; }
+.medium { background: #f6dd9c; }
+code, .hash { font-family: monospace; font-size: 0.9em; word-break: break-all; }
+table.meta td { padding: 0 1em 0 0; vertical-align: top; }
+.tag { background: #def; border-radius: 3px; padding: 0 0.3em; font-size: 0.85em; }
+.error { color: #a00; }
+</style>
+</head>
+<body>
+<h1>YAYA scan report</h1>
+<p class="muted">{{.Source}}, generated {{.Generated.Format "2006-01-02 15:04:05"}}</p>
+
+<h2>Summary</h2>
+<table class="summary">
+{{with .Scan}}<tr><td>Scan</td><td>{{.ID}} of <code>{{.Path}}</code>, {{.Status}}</td></tr>
+<tr><td>Started</td><td>{{.CreatedAt.Format "2006-01-02 15:04:05"}}</td></tr>
+{{with .FinishedAt}}<tr><td>Finished</td><td>{{.Format "2006-01-02 15:04:05"}}</td></tr>{{end}}{{end}}
+<tr><td>Files scanned</td><td>{{.Files}}</td></tr>
+<tr><td>Files matched</td><td>{{len .Matched}}</td></tr>
+<tr><td>Matches</td><td>{{.Matches}}</td></tr>
+<tr><td>Matches suppressed by the allowlist</td><td>{{.Suppressed}}</td></tr>
+<tr><td>Files with errors</td><td>{{len .Failed}}</td></tr>
+</table>
+
+<h2>Matched files</h2>
+{{range .Matched}}
+<div class="file">
+<span class="score {{if ge .Score 75}}high{{else if ge .Score 50}}medium{{end}}">{{.Score}}</span>
+<h3><code>{{.Path}}</code></h3>
+{{with .File}}<p class="hash">size {{.Size}}<br>md5 {{.MD5}}<br>sha1 {{.SHA1}}<br>sha256 {{.SHA256}}</p>{{end}}
+{{range .Matches}}
+<h4>{{.Rule}} <span class="muted">{{.Ruleset}}, score {{.Score}}</span> {{range .Tags}}<span class="tag">{{.}}</span> {{end}}</h4>
+<table class="meta">
+{{range .Meta}}<tr><td>{{.Identifier}}</td><td>{{$v := printf "%v" .Value}}{{if isLink $v}}<a href="{{$v}}">{{$v}}</a>{{else}}{{$v}}{{end}}</td></tr>{{end}}
+</table>
+{{with references .}}<p>References: {{range .}}{{if isLink .}}<a href="{{.}}">{{.}}</a>{{else}}{{.}}{{end}} {{end}}</p>{{end}}
+{{range .Strings}}<div><code>{{.Name}} at 0x{{printf "%x" .Offset}}: {{.Data}}</code></div>{{end}}
+{{end}}
+</div>
+{{else}}
+<p>No files matched.</p>
+{{end}}
+
+{{if .Failed}}
+<h2>Errors</h2>
+<ul>
+{{range .Failed}}<li><code>{{.Path}}</code>{{range .Errors}}<div class="error">{{.}}</div>{{end}}</li>{{end}}
+</ul>
+{{end}}
+
+<h2>Rulesets</h2>
+{{if .Rulesets}}<ul>{{range .Rulesets}}<li><code>{{.}}</code></li>{{end}}</ul>
+{{else}}<p class="muted">Ruleset versions are only known for reports generated from the scan history.</p>{{end}}
+</body>
+</html>
+`))
+
+// writeReport renders an HTML report for a scan in the history or a JSON lines results file
+func writeReport(db *gorm.DB, source, outputPath string) {
+	data := reportData{Source: source, Generated: time.Now()}
+	resultsPath := source
+	if id, err := strconv.Atoi(source); err == nil {
+		if exists, _ := Exists(source); !exists {
+			var scan Scan
+			if db.First(&scan, id).Error != nil {
+				log.Fatalf("Scan %d is not in the database", id)
+			}
+			data.Scan = &scan
+			data.Source = "scan " + source
+			if resultsPath, err = scan.resultsFile(db); err != nil {
+				log.Fatalln(err)
+			}
+			if scan.RulesetVersions != "" {
+				data.Rulesets = strings.Split(scan.RulesetVersions, "\n")
+			}
+		}
+	}
+
+	err := readResults(resultsPath, func(result *scanResult) {
+		data.Files++
+		data.Matches += len(result.Matches)
+		data.Suppressed += result.Suppressed
+		if len(result.Matches) > 0 {
+			data.Matched = append(data.Matched, result)
+		}
+		if len(result.Errors) > 0 {
+			data.Failed = append(data.Failed, result)
+		}
+	})
+	if err != nil {
+		log.Fatalf("Could not read results from %s: %s", resultsPath, err)
+	}
+	sort.SliceStable(data.Matched, func(i, j int) bool { return data.Matched[i].Score > data.Matched[j].Score })
+
+	f, err := os.Create(outputPath)
+	if err != nil {
+		log.Fatalf("Could not create report %s: %s", outputPath, err)
+	}
+	defer f.Close()
+	if err := reportTemplate.Execute(f, data); err != nil {
+		log.Fatalf("Could not write report: %s", err)
+	}
+	log.Printf("HTML report written to %s", outputPath)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Score for matches whose rule has no score or severity meta
+const defaultMatchScore = 50
+
+// Scores for textual severity and confidence meta values
+var severityScores = map[string]int{
+	"info": 10, "informational": 10, "low": 25, "medium": 50, "moderate": 50, "high": 75, "critical": 100,
+}
+var confidenceFactors = map[string]float64{
+	"low": 0.5, "medium": 0.75, "high": 1,
+}
+
+// matchFilter selects which matches a scan reports
+type matchFilter struct {
+	minScore int
+	tags     []string
+	rulesets []string
+}
+
+// metaValue returns the value of the first meta field with the given identifier
+func (match resultMatch) metaValue(identifier string) (interface{}, bool) {
+	for _, meta := range match.Meta {
+		if strings.EqualFold(meta.Identifier, identifier) {
+			return meta.Value, true
+		}
+	}
+	return nil, false
+}
+
+// metaNumber reads a meta value as a number, if it is one
+func metaNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case float64:
+		// meta read back from JSON
+		return v, true
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// matchScore rates a match from 0 to 100 using the score, severity and confidence meta fields of its rule
+func matchScore(match resultMatch) int {
+	score := float64(defaultMatchScore)
+	if value, ok := match.metaValue("score"); ok {
+		if n, ok := metaNumber(value); ok {
+			score = n
+		}
+	} else if value, ok := match.metaValue("severity"); ok {
+		if n, ok := metaNumber(value); ok {
+			score = n
+		} else if n, ok := severityScores[strings.ToLower(fmt.Sprint(value))]; ok {
+			score = float64(n)
+		}
+	}
+	if value, ok := match.metaValue("confidence"); ok {
+		if n, ok := metaNumber(value); ok && n <= 1 {
+			score *= n
+		} else if ok && n <= 100 {
+			score *= n / 100
+		} else if factor, ok := confidenceFactors[strings.ToLower(fmt.Sprint(value))]; ok {
+			score *= factor
+		}
+	}
+	if score > 100 {
+		score = 100
+	}
+	return int(score)
+}
+
+// keep reports whether a match passes the filter
+func (filter matchFilter) keep(match resultMatch) bool {
+	if match.Score < filter.minScore {
+		return false
+	}
+	if len(filter.rulesets) > 0 && !containsFold(filter.rulesets, match.Ruleset) {
+		return false
+	}
+	if len(filter.tags) > 0 {
+		for _, tag := range match.Tags {
+			if containsFold(filter.tags, tag) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// applyScores scores a result's matches, drops the ones the filter rejects and sorts the rest highest score first
+// A file scores as much as its highest scoring match.
+func applyScores(result *scanResult, filter matchFilter) {
+	var kept []resultMatch
+	for _, match := range result.Matches {
+		match.Score = matchScore(match)
+		if filter.keep(match) {
+			kept = append(kept, match)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].Score > kept[j].Score })
+	result.filtered += len(result.Matches) - len(kept)
+	result.Matches = kept
+	result.Score = 0
+	if len(kept) > 0 {
+		result.Score = kept[0].Score
+	}
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// scoredFile is a matched file in the end of scan summary
+type scoredFile struct {
+	path    string
+	score   int
+	matches int
+}
+
+// printScoreSummary lists the matched files highest score first
+func printScoreSummary(files []scoredFile) {
+	sort.SliceStable(files, func(i, j int) bool { return files[i].score > files[j].score })
+	log.Printf("%d files matched:", len(files))
+	for _, file := range files {
+		log.Printf("  %3d %s (%d matches)", file.score, file.path, file.matches)
+	}
+}
@@ -1,30 +1,29 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/hillu/go-yara/v4"
-	"github.com/jinzhu/gorm"
-	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/EFForg/yaya/pkg/yaya"
 )
 
 // Consts
-const awesomeListURL = "https://raw.githubusercontent.com/InQuest/awesome-yara/master/README.md"
+const stdinLabel = "<stdin>"
 
-// installDefaultRules clones the rulesets listed in defaultRulesets
-func installDefaultRules() {
-	db := openDB()
-	defer db.Close()
-
-	for _, ruleset := range defaultRulesets {
-		// Create or update ruleset in db
-		db.Where(Ruleset{Name: ruleset.Name}).Assign(ruleset).FirstOrCreate(&ruleset)
-		rulesets = append(rulesets, ruleset)
-	}
-	fmt.Printf("Downloading %d rulesets...\n", len(rulesets))
+// defaultOutputPath is where a scan writes its results without -output, every scan gets its own file so resuming or reporting on one never reads another's
+func defaultOutputPath(scanID uint, format string) string {
+	return fmt.Sprintf("/tmp/yaya-%d.%s", scanID, format)
 }
 
 // Exists returns whether a given path exists
@@ -46,38 +45,214 @@ func Warning(err error) {
 	}
 }
 
-// printMatches prints match results to the screen in a human readable way
-func printMatches(results map[string][]yara.MatchRule) {
-	for filePath, matches := range results {
-		log.Printf("%s:", filePath)
-		if len(matches) > 0 {
-			for _, match := range matches {
-				log.Printf("  - [%s] %s ", match.Namespace, match.Rule)
-			}
-		} else {
-			log.Print("  - no matches.")
+// isTimeout reports whether a scan gave up because it hit the timeout
+func isTimeout(err error) bool {
+	return strings.Contains(err.Error(), "scan timeout")
+}
+
+// describeScanError explains why a file could not be scanned, and whether it is gone for good
+func describeScanError(path string, err error) (string, bool) {
+	_, statErr := os.Stat(path)
+	switch {
+	case os.IsNotExist(statErr):
+		return "file vanished before it could be scanned", true
+	case os.IsPermission(statErr):
+		return "permission denied", true
+	}
+	if f, openErr := os.Open(path); os.IsPermission(openErr) {
+		return "permission denied", true
+	} else if openErr == nil {
+		f.Close()
+	}
+	return err.Error(), false
+}
+
+// printResult prints the result of scanning one file to the screen in a human readable way
+func printResult(result *scanResult, details bool) {
+	log.Printf("%s:", result.Path)
+	if result.File != nil && len(result.Matches) > 0 {
+		log.Printf("  size %d md5 %s sha1 %s sha256 %s", result.File.Size, result.File.MD5, result.File.SHA1, result.File.SHA256)
+	}
+	for _, e := range result.Errors {
+		log.Printf("  - error: %s", e)
+	}
+	if len(result.Matches) > 0 {
+		for _, match := range result.Matches {
+			printMatch(match, details)
 		}
+	} else if result.Suppressed > 0 {
+		log.Printf("  - %d matches suppressed by the allowlist.", result.Suppressed)
+	} else if result.filtered > 0 {
+		log.Printf("  - %d matches left out by the filters.", result.filtered)
+	} else if len(result.Errors) == 0 {
+		log.Print("  - no matches.")
 	}
 }
 
-// saveMatchesJSON saves match results to json file for later processing
-func saveMatchesJSON(results map[string][]yara.MatchRule) {
-	outpath := "/tmp/yaya.json"
+// Output formats
+const (
+	formatJSONL = "jsonl"
+	formatCSV   = "csv"
+)
+
+// csvHeader are the CSV columns, matched strings, tags and meta are nested so -strings needs jsonl
+var csvHeader = []string{"path", "score", "size", "mtime", "md5", "sha1", "sha256", "ruleset", "namespace", "rule", "errors"}
 
-	txt, err := json.Marshal(results)
-	if err != nil {
+// resultWriter streams scan results as JSON lines or CSV for later processing
+type resultWriter struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+	csv *csv.Writer
+}
+
+// newResultWriter writes results to the file at outpath, or stdout if outpath is -
+func newResultWriter(outpath, format string, appending bool) (*resultWriter, error) {
+	f := os.Stdout
+	if outpath != "-" {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if appending {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		var err error
+		f, err = os.OpenFile(outpath, flags, 0666)
+		if err != nil {
+			return nil, err
+		}
+	}
+	w := &resultWriter{f: f}
+	if format == formatCSV {
+		w.csv = csv.NewWriter(f)
+		if !appending {
+			w.csv.Write(csvHeader)
+			w.csv.Flush()
+		}
+	} else {
+		w.enc = json.NewEncoder(f)
+	}
+	return w, nil
+}
+
+// write appends one result, it is unbuffered so interrupted scans keep what they found
+func (w *resultWriter) write(result *scanResult) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.csv != nil {
+		w.writeCSV(result)
+		return
+	}
+	if err := w.enc.Encode(result); err != nil {
 		log.Panicf("Marshaling error: %s", err)
 	}
+}
 
-	f, err := os.Create(outpath)
+// writeCSV writes a row for every match, or one row without a rule for a result without matches
+func (w *resultWriter) writeCSV(result *scanResult) {
+	// size, mtime and hashes are empty when the file wasn't hashed
+	file := make([]string, 5)
+	if result.File != nil {
+		file = []string{strconv.FormatInt(result.File.Size, 10), "", result.File.MD5, result.File.SHA1, result.File.SHA256}
+		if result.File.ModTime != nil {
+			file[1] = result.File.ModTime.Format(time.RFC3339)
+		}
+	}
+	errors := strings.Join(result.Errors, "; ")
+	if len(result.Matches) == 0 {
+		w.csv.Write(append(append([]string{result.Path, "0"}, file...), "", "", "", errors))
+	}
+	for _, match := range result.Matches {
+		w.csv.Write(append(append([]string{result.Path, strconv.Itoa(match.Score)}, file...), match.Ruleset, match.Namespace, match.Rule, errors))
+	}
+	w.csv.Flush()
+	if err := w.csv.Error(); err != nil {
+		log.Panicf("CSV error: %s", err)
+	}
+}
+
+func (w *resultWriter) Close() {
+	if w.f != os.Stdout {
+		w.f.Close()
+	}
+}
+
+// readResults calls fn for every result in a JSON lines file written by resultWriter
+func readResults(path string, fn func(*scanResult)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if first, err := r.Peek(1); err == nil && first[0] != '{' {
+		return fmt.Errorf("%s is not a JSON lines results file, CSV results can't be read back", path)
+	}
+	dec := json.NewDecoder(r)
+	for dec.More() {
+		var result scanResult
+		if err := dec.Decode(&result); err != nil {
+			return err
+		}
+		fn(&result)
+	}
+	return nil
+}
+
+// recoverOutput drops a partly written last result from the output of an interrupted scan and returns the paths of the results in it
+func recoverOutput(path, format string) ([]string, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
 	defer f.Close()
+	data, err := ioutil.ReadAll(f)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return nil, err
+	}
+	if complete := bytes.LastIndexByte(data, '\n') + 1; complete < len(data) {
+		log.Printf("Dropping a partly written result from the end of %s", path)
+		if err := f.Truncate(int64(complete)); err != nil {
+			return nil, err
+		}
+		data = data[:complete]
 	}
 
-	f.Write(txt)
-	log.Printf("json output written to %s", outpath)
+	var paths []string
+	if format == formatCSV {
+		r := csv.NewReader(bytes.NewReader(data))
+		r.FieldsPerRecord = -1
+		records, err := r.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		for i, record := range records {
+			if i > 0 && len(record) > 0 {
+				paths = append(paths, record[0])
+			}
+		}
+		return paths, nil
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var result scanResult
+		if len(line) > 0 && json.Unmarshal(line, &result) == nil {
+			paths = append(paths, result.Path)
+		}
+	}
+	return paths, nil
+}
+
+// parseArgs parses flags that may come before, between or after a command's arguments and returns the arguments
+func parseArgs(flags *flag.FlagSet, rest []string) []string {
+	var args []string
+	for {
+		flags.Parse(rest)
+		if flags.NArg() == 0 {
+			return args
+		}
+		args = append(args, flags.Arg(0))
+		rest = flags.Args()[1:]
+	}
 }
 
 // usage prints help about the program
@@ -85,29 +260,63 @@ func usage() {
 	fmt.Print(""+
 		"YAYA - Yet Another Yara Automaton\n"+
 		"Usage:\n"+
-		os.Args[0], " [-h] <command> <path>\n"+
+		os.Args[0], " [-h] <command> [options] <path>\n"+
 		"\t-h\t print this help screen\n"+
 		"Commands:\n"+
+		"\tinit [defaults|empty|<catalog file>] - set yaya up with the default rulesets, none, or a catalog; otherwise the first command other than `catalog import` uses the defaults\n"+
 		"\tupdate - update rulesets\n"+
 		"\tedit - ban or remove rulesets\n"+
 		"\tadd - add a custom ruleset, located at <path>\n"+
-		"\tscan - perform a yara scan on the directory at <path>\n"+
-		"\texport - export all yara rules in single yar file in <path>\n")
+		"\tscan - perform a yara scan on the directory at <path>, or on stdin if <path> is -\n"+
+		"\texport - export all yara rules in single yar file in <path>\n"+
+		"\tscans - list the scan history\n"+
+		"\treport <scan id|results file> [report.html] - write an HTML report of a scan (default "+defaultReportPath+")\n"+
+		"\tquarantine list|restore <id>|purge <id> - manage files quarantined by scan -quarantine\n"+
+		"\tallowlist list|add|remove <id> - manage known good files whose matches are suppressed\n"+
+		"\tallowlist from-scan <scan id> <path> - allowlist the rules that matched <path> in a past scan\n"+
+		"\trules broken - list rules disabled because they failed to compile\n"+
+		"\trules duplicates - list rule files vendored whole by more than one ruleset\n"+
+		"\trules lint - list yara compiler warnings for enabled rules\n"+
+		"\trules slow - list rules scan -disable-slow left out because yara warns they slow down scanning\n"+
+		"\tcatalog export|import <file> - share rulesets and disabled rules with another yaya, - for stdout or stdin\n"+
+		"\tcatalog sync [README.md] - compare the rulesets with the awesome-yara list, or a saved copy of it\n"+
+		"\tconfig show - print the settings in use, from the config file, environment and defaults\n"+
+		"Options:\n"+
+		"\t-dedup <policy> - for scan and export, skip duplicate rules keeping the first one or prefer:<ruleset>\n"+
+		"\t-profile - for scan, report the slowest rulesets and rules\n"+
+		"\t-disable-slow - for scan, leave out rules that yara warns are slowing down scanning from later scans\n"+
+		"\t-pid <pid> - for scan, scan the memory of a running process (Linux)\n"+
+		"\t-all-processes - for scan, scan the memory of every running process (Linux)\n"+
+		"\t-archives - for scan, also scan the members of zip, tar and gzip archives as archive.zip!member\n"+
+		"\t-archive-depth <n>, -archive-members <n>, -archive-size <MB> - limits on archive expansion\n"+
+		"\t-min-size <size>, -max-size <size> - for scan, skip files smaller or larger than this (e.g. 1K, 2G)\n"+
+		"\t-include <globs>, -exclude <globs> - for scan, only scan or skip files matching comma separated globs\n"+
+		"\t-exclude-dir <globs> - for scan, skip these directories (default "+defaultExcludeDirs+")\n"+
+		"\t-follow-symlinks - for scan, follow symlinks instead of skipping them\n"+
+		"\t-one-filesystem - for scan, don't descend into other filesystems\n"+
+		"\t-timeout <duration> - for scan, give up on a single file after this long (default 1m)\n"+
+		"\t-output <path> - for scan, write results to <path> or - for stdout (default /tmp/yaya-<scan id>.jsonl)\n"+
+		"\t-format jsonl|csv - for scan, the format to write results in, report, allowlist from-scan and -strings need jsonl (default jsonl)\n"+
+		"\t-concurrency <n> - for scan, how many files to scan at once, at most 64 (default 1)\n"+
+		"\t-resume <scan id> - for scan, resume an interrupted scan skipping the paths it already scanned\n"+
+		"\t-full - for scan, also scan files that are unchanged since they last scanned clean\n"+
+		"\t-strings - for scan, show matched strings with their offsets, tags and meta\n"+
+		"\t-snippet-bytes <n> - with -strings, how many bytes of each matched string to show in hex (default 32)\n"+
+		"\t-hash-all - for scan, include hashes of every scanned file, not just the ones that matched\n"+
+		"\t-quarantine <dir> - for scan, move matched files into <dir>, see the quarantine command\n"+
+		"\t-quarantine-copy - with -quarantine, copy matched files instead of moving them\n"+
+		"\t-min-score <n> - for scan, only report matches scoring at least <n> (0-100) from score, severity and confidence meta\n"+
+		"\t-tag <tags>, -ruleset <names> - for scan, only report matches with these comma separated tags or from these rulesets\n"+
+		"\t-sort - for scan, finish with a list of matched files, highest score first\n"+
+		"\t-sha256 <hash>, -path-glob <glob>, -rule <rule>, -note <text> - for allowlist add, what to allowlist\n"+
+		"\t-any-rule - for allowlist from-scan, allowlist the file for every rule instead of just the ones that matched\n"+
+		"\t-apply - for catalog sync, add the new rulesets and disable the ones no longer listed\n")
 	os.Exit(1)
 }
 
-func openDB() *gorm.DB {
-	db, err := gorm.Open("sqlite3", dbPath)
-	//db.LogMode(true)
-	if err != nil {
-		panic("failed to connect database")
-	}
-	return db
-}
-
-func printRulesets(rulesets []Ruleset) {
+func printRulesets(rulesets []yaya.Ruleset) {
 	fmt.Printf("%8s %s\t%45.45s\t%.45s\n", "Enabled", "ID", "Name", "Description")
 	for _, ruleset := range rulesets {
-		fmt.Printf("%8s %d\t%45.45s\t%.45s\n", ruleset.getStatus(), ruleset.ID, ruleset.Name, ruleset.Description)
+		fmt.Printf("%8s %d\t%45.45s\t%.45s\n", ruleset.Status(), ruleset.ID, ruleset.Name, ruleset.Description)
 	}
 }
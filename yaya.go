@@ -6,437 +6,722 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
-	"path"
-	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
-	"io/ioutil"
+	"sync"
+	"time"
 
-	"github.com/go-git/go-git/v5"
 	"github.com/hillu/go-yara/v4"
-	"github.com/jinzhu/gorm"
-	_ "github.com/jinzhu/gorm/dialects/sqlite"
-)
 
-// Ruleset is a record for a yara ruleset
-type Ruleset struct {
-	gorm.Model
-	Name        string `gorm:"unique_index"`
-	URL         string
-	Description string
-	Enabled     bool `gorm:"default:true"`
-	Rules       []Rule
-}
+	"github.com/EFForg/yaya/pkg/yaya"
+)
 
-func (ruleset *Ruleset) toggleEnabled() {
-	db := openDB()
-	defer db.Close()
-	ruleset.Enabled = !ruleset.Enabled
-	db.Save(&ruleset)
+// scanResult is what scanning one file, buffer or process found
+type scanResult struct {
+	Path    string        `json:"path"`
+	Score   int           `json:"score"`
+	File    *resultFile   `json:"file,omitempty"`
+	Matches []resultMatch `json:"matches"`
+	// Suppressed counts matches dropped by the allowlist
+	Suppressed int      `json:"suppressed,omitempty"`
+	Errors     []string `json:"errors,omitempty"`
+	// filtered counts matches dropped by -min-score, -tag and -ruleset, they are not reported but the file didn't scan clean
+	filtered int
 }
 
-func (ruleset *Ruleset) getStatus() string {
-	var status string
-	if ruleset.Enabled {
-		status = "enabled"
-	} else {
-		status = "disabled"
+// addError records a scan error once, however many rulesets hit it
+func (result *scanResult) addError(msg string) {
+	for _, e := range result.Errors {
+		if e == msg {
+			return
+		}
 	}
-	return status
+	result.Errors = append(result.Errors, msg)
 }
 
-// Rule is an individual YARA rule
-type Rule struct {
-	gorm.Model
-	Namespace string
-	Path      string
-	Enabled   bool `gorm:"default:true"`
-	Ruleset   Ruleset
-	RulesetID uint
-}
-
-func (rule *Rule) toggleEnabled() {
-	db := openDB()
-	defer db.Close()
-	rule.Enabled = !rule.Enabled
-	db.Save(&rule)
-}
-
-// Collections
-var rulesets []Ruleset
-var rules []Rule
-var scanResults = map[string][]yara.MatchRule{}
-
-// Paths
-var home, _ = os.UserHomeDir()
-var configPath = path.Join(home, ".yaya")
-var rulesetsPath = path.Join(configPath, "rulsets")
-var dbPath = path.Join(configPath, "yaya.db")
-
 func main() {
-	// Make config directories if they don't exist
-	os.MkdirAll(rulesetsPath, os.ModePerm)
-
 	if !(len(os.Args) >= 2) || os.Args[1] == "-h" {
 		usage()
 	}
 
-	db := openDB()
-	defer db.Close()
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := useProxy(cfg.values["proxy"]); err != nil {
+		log.Fatalln(err)
+	}
+
+	// The store makes the config directories if they don't exist and migrates the rules schema
+	store, err := yaya.NewStore(cfg.storeOptions())
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer store.Close()
+	rulesets := yaya.NewRulesetManager(store)
 
 	// Migrate the schema
-	db.AutoMigrate(&Rule{})
-	db.AutoMigrate(&Ruleset{})
+	db := store.DB()
+	db.AutoMigrate(&Scan{})
+	db.AutoMigrate(&ScannedPath{})
+	db.AutoMigrate(&FileState{})
+	db.AutoMigrate(&QuarantinedFile{})
+	db.AutoMigrate(&AllowlistEntry{})
 
 	command := os.Args[1]
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.Usage = usage
+	dedup := flags.String("dedup", yaya.DedupNone, "skip duplicate rules: first, or prefer:<ruleset>")
+	profile := flags.Bool("profile", false, "report the slowest rulesets and rules after scanning")
+	disableSlow := flags.Bool("disable-slow", false, "leave out rules that yara warns are slowing down scanning from later scans")
+	pid := flags.Int("pid", 0, "scan the memory of the process with this pid")
+	allProcesses := flags.Bool("all-processes", false, "scan the memory of every running process")
+	archives := flags.Bool("archives", false, "scan the members of zip, tar and gzip archives")
+	archiveDepth := flags.Int("archive-depth", 3, "how many levels of nested archives to expand")
+	archiveMembers := flags.Int("archive-members", 10000, "the most members to expand from one archive")
+	archiveSize := flags.Int64("archive-size", 512, "the most megabytes to decompress from one archive")
+	minSize := flags.String("min-size", "0", "skip files smaller than this, e.g. 1K")
+	maxSize := flags.String("max-size", "0", "skip files larger than this, e.g. 2G, 0 for no limit")
+	include := flags.String("include", "", "only scan files matching these comma separated globs")
+	exclude := flags.String("exclude", "", "skip files matching these comma separated globs")
+	excludeDirs := flags.String("exclude-dir", defaultExcludeDirs, "skip directories matching these comma separated globs")
+	followSymlinks := flags.Bool("follow-symlinks", false, "follow symlinks instead of skipping them")
+	oneFilesystem := flags.Bool("one-filesystem", false, "don't descend into other filesystems")
+	timeout := flags.Duration("timeout", time.Minute, "give up scanning a single file after this long, 0 for no limit")
+	output := flags.String("output", "", "write results to this file, - for stdout (default /tmp/yaya-<scan id>.jsonl)")
+	format := flags.String("format", formatJSONL, "write results as jsonl or csv")
+	concurrency := flags.Int("concurrency", 1, "how many files to scan at once")
+	resume := flags.Uint("resume", 0, "resume an interrupted scan, skipping the paths it already scanned")
+	full := flags.Bool("full", false, "scan files even if they are unchanged since they last scanned clean")
+	showStrings := flags.Bool("strings", false, "include matched strings, offsets, tags and meta in the output")
+	snippetBytes := flags.Int("snippet-bytes", 32, "how much of each matched string to include with -strings")
+	hashAll := flags.Bool("hash-all", false, "include hashes for every scanned file, not just the ones that matched")
+	quarantine := flags.String("quarantine", "", "move matched files into this directory")
+	quarantineCopy := flags.Bool("quarantine-copy", false, "with -quarantine, copy matched files instead of moving them")
+	minScore := flags.Int("min-score", 0, "only report matches scoring at least this much (0-100)")
+	tags := flags.String("tag", "", "only report matches of rules with one of these comma separated tags")
+	rulesetNames := flags.String("ruleset", "", "only report matches from these comma separated rulesets")
+	sortScore := flags.Bool("sort", false, "finish with a list of matched files, highest score first")
+	sha256 := flags.String("sha256", "", "the SHA-256 of a file to allowlist")
+	pathGlob := flags.String("path-glob", "", "a glob of paths to allowlist")
+	rule := flags.String("rule", "", "with -sha256, only allowlist this rule on the file")
+	note := flags.String("note", "", "why an allowlist entry was added")
+	apply := flags.Bool("apply", false, "for catalog sync, make the proposed changes")
+	anyRule := flags.Bool("any-rule", false, "allowlist a file from a scan for every rule, not just the ones that matched")
+	if err := cfg.applyDefaults(flags); err != nil {
+		log.Fatalln(err)
+	}
+	args := parseArgs(flags, os.Args[2:])
 	var path string = ""
-	if len(os.Args) > 2 {
-		path = os.Args[2]
+	var rest []string
+	if len(args) > 0 {
+		path = args[0]
+		rest = args[1:]
+	}
+	if !yaya.ValidDedupPolicy(*dedup) {
+		log.Fatalf("Unknown dedup policy %q, use first or prefer:<ruleset>.", *dedup)
 	}
 
-	initYaya(db)
+	if *format != formatJSONL && *format != formatCSV {
+		log.Fatalf("Unknown output format %q, use jsonl or csv.", *format)
+	}
+	if *format == formatCSV && *showStrings {
+		log.Fatalln("-strings needs -format jsonl, CSV has no room for matched strings, tags and meta.")
+	}
+	if *concurrency < 1 || *concurrency > maxConcurrency {
+		log.Fatalf("-concurrency must be between 1 and %d.", maxConcurrency)
+	}
+
+	// init sets yaya up itself, and a catalog import is a way to set it up, see below
+	firstRun := knownCommands[command] && command != "init" && command != "config" && !(command == "catalog" && path == "import")
+	autoInit := firstRun && !store.Initialized()
+	if autoInit {
+		fmt.Println("Running YAYA for the first time. Gathering initial rulesets, run `init empty` or `init <catalog>` instead to start differently.")
+		initYaya(store, rulesets, initDefaults)
+	}
 
 	switch command {
+	case "init":
+		initYaya(store, rulesets, path)
 	case "update":
-		updateRules()
+		if autoInit {
+			// initializing just downloaded and validated every ruleset
+			reportDuplicates(store)
+			break
+		}
+		updateRules(store, rulesets)
 	case "edit":
-		editRules()
+		editRules(rulesets)
 	case "add":
 		if path == "" {
 			log.Fatalln("You must specify a ruleset path or github url to add.")
 		}
-		addRuleset(path)
+		addRuleset(rulesets, path)
 	case "scan":
-		if path == "" {
-			log.Fatalln("You must specify a path to scan.")
+		if path == "" && *pid == 0 && !*allProcesses && *resume == 0 {
+			log.Fatalln("You must specify a path, -pid or -all-processes to scan.")
+		}
+		filter := scanFilter{
+			include:        splitList(*include),
+			exclude:        splitList(*exclude),
+			excludeDirs:    splitList(*excludeDirs),
+			followSymlinks: *followSymlinks,
+			oneFilesystem:  *oneFilesystem,
+		}
+		if filter.minSize, err = parseSize(*minSize); err != nil {
+			log.Fatalln(err)
+		}
+		if filter.maxSize, err = parseSize(*maxSize); err != nil {
+			log.Fatalln(err)
+		}
+		if !*showStrings {
+			*snippetBytes = 0
 		}
-		runScan(path)
+		runScan(store, rulesets, path, scanOptions{
+			dedup:          *dedup,
+			profile:        *profile,
+			disableSlow:    *disableSlow,
+			pid:            *pid,
+			allProcesses:   *allProcesses,
+			archives:       *archives,
+			archive:        archiveLimits{depth: *archiveDepth, members: *archiveMembers, size: *archiveSize << 20},
+			filter:         filter,
+			timeout:        *timeout,
+			output:         *output,
+			format:         *format,
+			concurrency:    *concurrency,
+			resume:         *resume,
+			full:           *full,
+			snippetBytes:   *snippetBytes,
+			hashAll:        *hashAll,
+			quarantine:     *quarantine,
+			quarantineCopy: *quarantineCopy,
+			matchFilter:    matchFilter{minScore: *minScore, tags: splitList(*tags), rulesets: splitList(*rulesetNames)},
+			sortScore:      *sortScore,
+		})
 	case "export":
 		if path == "" {
 			log.Fatalln("You must specify an output path.")
 		}
-		exportRules(path)
+		exportRules(store, path, *dedup)
+	case "scans":
+		printScans(db)
+	case "report":
+		if path == "" {
+			log.Fatalln("You must specify a scan ID or a results file to report on.")
+		}
+		reportPath := defaultReportPath
+		if len(rest) > 0 {
+			reportPath = rest[0]
+		}
+		writeReport(db, path, reportPath)
+	case "quarantine":
+		quarantineCommand(db, path, firstArg(rest))
+	case "allowlist":
+		entry := AllowlistEntry{SHA256: *sha256, PathGlob: *pathGlob, Rule: *rule, Note: *note}
+		allowlistCommand(db, path, rest, entry, *anyRule)
+	case "rules":
+		switch path {
+		case "broken":
+			printBrokenRules(store)
+		case "duplicates":
+			printDuplicateRules(store)
+		case "lint":
+			printRuleWarnings(store)
+		case "slow":
+			printSlowRules(store)
+		default:
+			log.Fatalln("You must specify a rules report (broken, duplicates, lint, slow).")
+		}
+	case "catalog":
+		catalogCommand(rulesets, path, firstArg(rest), *apply)
+		if path == "import" {
+			// the imported rulesets are what the user chose to start with
+			store.MarkInitialized()
+		}
+	case "config":
+		if path != "show" {
+			log.Fatalln("You must specify a config action (show).")
+		}
+		cfg.show(store, flags)
 	case "exportcompiled":
 		if path == "" {
 			log.Fatalln("You must specify an output path.")
 		}
-		exportRulesCompiled(path)
+		exportRulesCompiled(store, path, *dedup)
 	default:
 		fmt.Println("Command not recognized")
 		usage()
 	}
 }
 
-//initYara populates the ruleset database with repos from the awesomelist
-func initYaya(db *gorm.DB) {
-	var count int
-	db.Table("rulesets").Count(&count)
-	if count > 1 {
-		return
-	}
-	fmt.Println("Running YAYA for the first time. Gathering initial rulesets.")
-	installDefaultRules()
-	updateRules()
-	os.Exit(0)
-}
-
-// updateRules checks git repostitories for any new rules that have been added
-func updateRules() {
-	log.Println("Updating YARA Rules...")
-	pullRulesets()
-	findRules()
-}
-
-func loadRulesets(rulesets *[]Ruleset) {
-	db := openDB()
-	defer db.Close()
-
-	db.Where("enabled = ?", true).Find(&rulesets)
+// knownCommands are the commands main handles, an unknown one only prints the usage
+var knownCommands = map[string]bool{
+	"init": true, "update": true, "edit": true, "add": true, "scan": true, "export": true, "scans": true, "report": true,
+	"quarantine": true, "allowlist": true, "rules": true, "catalog": true, "config": true, "exportcompiled": true,
 }
 
-func loadAllRulesets(rulesets *[]Ruleset) {
-	db := openDB()
-	defer db.Close()
-
-	db.Find(&rulesets)
-}
+// Ways to initialize yaya, anything else is a catalog file to initialize from
+const (
+	initDefaults = "defaults"
+	initEmpty    = "empty"
+)
 
-func pullRulesets() {
-	loadRulesets(&rulesets)
-	for _, ruleset := range rulesets {
-		pullRuleset(&ruleset)
+// initYaya populates the ruleset database with the default repos from the awesome list, nothing, or a catalog
+// Once initialized yaya never does it again by itself, even when every ruleset is removed.
+func initYaya(store *yaya.Store, rulesets *yaya.RulesetManager, from string) {
+	if store.Initialized() {
+		log.Printf("YAYA is already initialized, adding to the %d rulesets there are", rulesets.Count())
 	}
-}
-
-func pullRuleset(ruleset *Ruleset) {
-	rulesetPath := path.Join(rulesetsPath, ruleset.Name)
-	pathExists, _ := Exists(rulesetPath)
-	if !pathExists {
-		log.Printf("git clone %q", ruleset.URL)
-
-		_, err := git.PlainClone(rulesetPath, false, &git.CloneOptions{
-			URL: ruleset.URL,
-		})
+	switch from {
+	case "", initDefaults:
+		fmt.Printf("Downloading %d rulesets...\n", rulesets.InstallDefaults())
+		rulesets.Update()
+	case initEmpty:
+		fmt.Println("Starting without rulesets, add some with `add` or `catalog import`.")
+	default:
+		changes, err := importCatalogFile(rulesets, from)
 		if err != nil {
-			log.Println(err)
+			log.Fatalln(err)
 		}
-	} else {
-		log.Printf("git pull %s", ruleset.Name)
-		// We instantiate a new repository targeting the given path (the .git folder)
-		r, err := git.PlainOpen(rulesetPath)
-    Warning(err)
-
-		// Get the working directory for the repository
-		w, err := r.Worktree()
-		Warning(err)
-
-		// Pull the latest changes from the origin remote and merge into the current branch
-		err = w.Pull(&git.PullOptions{RemoteName: "origin"})
-    if err != nil && !(strings.Contains(err.Error(), "already")) {
-      Warning(err)
-    }
-	}
-}
-
-func findRules() {
-	db := openDB()
-	defer db.Close()
-	loadRulesets(&rulesets)
-	for _, ruleset := range rulesets {
-		updateRulesetRules(&ruleset, db)
+		fmt.Printf("Added %d rulesets from %s, downloading them...\n", changes.Added, from)
+		rulesets.Update()
 	}
+	store.MarkInitialized()
 }
 
-func updateRulesetRules(ruleset *Ruleset, db *gorm.DB) {
-	rulesetPath := path.Join(rulesetsPath, ruleset.Name)
-	pathExists, _ := Exists(rulesetPath)
-	if !ruleset.Enabled {
-		return
-	}
-	log.Printf("loading %q located at %s", ruleset.Name, rulesetPath)
-	if !pathExists {
-		Warning(fmt.Errorf("the ruleset path %s doesn't exist, disabling for now", rulesetPath))
-		ruleset.Enabled = false
-		db.Save(&ruleset)
-		return
-	}
-
-	// scan ruleset path for *.yar[a]?$
-	filepath.Walk(rulesetPath, func(path string, info os.FileInfo, e error) error {
-		if e != nil {
-			return e
-		}
-
-		// check if it is a regular file (not dir)
-		if info.Mode().IsRegular() {
-			matched, _ := filepath.Match(`*.yar*`, info.Name())
-			if matched {
-				// create struct Rule and append to ruleset
-				r := Rule{Path: path, RulesetID: ruleset.ID}
-				record := db.FirstOrCreate(&r, r)
-				rulename := strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
-				r.Namespace = fmt.Sprintf("%s:%s-%d", ruleset.Name, rulename, r.ID)
-				db.Save(&r)
-				// validate yara rule
-				if r.Enabled {
-					c, _ := yara.NewCompiler()
-					f, err := os.Open(r.Path)
-					if err != nil {
-						log.Printf("Could not open rule file %s: %s\n", r.Path, err)
-						return nil
-					}
-					err = c.AddFile(f, r.Namespace)
-					f.Close()
-					if err != nil {
-						log.Printf("Could not parse rule file %s: %s", r.Path, err)
-						r.Enabled = false
-						db.Save(&r)
-					}
-				}
-
-				db.Model(&ruleset).Association("Rules").Append(record)
-			}
-		}
-		return nil
-	})
-	// DB update ruleset
-	db.Save(&ruleset)
-
+// updateRules checks git repostitories for any new rules that have been added
+func updateRules(store *yaya.Store, rulesets *yaya.RulesetManager) {
+	rulesets.Update()
+	reportDuplicates(store)
 }
 
 // editRules presents a UI allowing the user to ban certain rulesets or individual rules
-func editRules() {
-	loadAllRulesets(&rulesets)
-	printRulesets(rulesets)
+func editRules(rulesets *yaya.RulesetManager) {
+	printRulesets(rulesets.All())
 
 	fmt.Print("Enter ruleset indexes (space seperated) to toggle: ")
 
-	db := openDB()
-	defer db.Close()
 	reader := bufio.NewReader(os.Stdin)
 	text, _ := reader.ReadString('\n')
 	text = strings.TrimSuffix(text, "\n")
 	input := strings.Split(text, " ")
 	for _, next := range input {
-		var ruleset Ruleset
 		idx, err := strconv.Atoi(next)
 		if err != nil {
 			log.Panicf("Couldn't parse input: %s", err)
 		}
-		if db.First(&ruleset, idx).Error != nil {
+		ruleset, err := rulesets.Toggle(uint(idx))
+		if err != nil {
 			fmt.Printf("Ruleset %d is not in the database\n", idx)
 			continue
 		}
-		ruleset.toggleEnabled()
-		fmt.Printf("Ruleset %d \"%s\" is now %s\n", ruleset.ID, ruleset.Name, ruleset.getStatus())
+		fmt.Printf("Ruleset %d \"%s\" is now %s\n", ruleset.ID, ruleset.Name, ruleset.Status())
 	}
-	return
 }
 
 // addRuleset allows the user to add a ruleset from either a git repository or local file path
-func addRuleset(path string) {
-	db := openDB()
-	defer db.Close()
+func addRuleset(rulesets *yaya.RulesetManager, path string) {
 	fmt.Println("Adding YARA Rules from ", path)
-	match, _ := regexp.MatchString(`\.git$`, path)
-	if !match {
-		// Not a successfull match so lets continue to the next line
-		log.Panicln("not a git repository")
-	}
-
-	s := strings.TrimSuffix(path, ".git")
-	name := s[strings.LastIndex(s, "/")+1:]
-	description := "Custom yara rules"
-	ruleset := Ruleset{Name: name, URL: path, Description: description, Enabled: true}
-	fmt.Printf("creating ruleset %+v\n", ruleset)
-	// Create or update ruleset in db
-	db.Create(&ruleset)
-	pullRuleset(&ruleset)
-	updateRulesetRules(&ruleset, db)
+	if _, err := rulesets.Add(path); err != nil {
+		log.Fatalln(err)
+	}
 }
 
-// runScan Scan a path recursively with every rule in the database
-func runScan(scanPath string) {
-	db := openDB()
-	defer db.Close()
+// maxConcurrency bounds -concurrency, every concurrent scan has its own yara scanners and preloaded file
+const maxConcurrency = 64
+
+// scanOptions control how runScan behaves
+type scanOptions struct {
+	dedup          string
+	profile        bool
+	disableSlow    bool
+	pid            int
+	allProcesses   bool
+	archives       bool
+	archive        archiveLimits
+	filter         scanFilter
+	timeout        time.Duration
+	output         string
+	format         string
+	concurrency    int
+	resume         uint
+	full           bool
+	snippetBytes   int
+	hashAll        bool
+	quarantine     string
+	quarantineCopy bool
+	matchFilter    matchFilter
+	sortScore      bool
+	allowlist      allowlist
+}
 
-	filepath.Walk(scanPath, func(path string, info os.FileInfo, e error) error {
-		if e != nil {
-			return e
+// scanTarget is a file, buffer or process to scan
+type scanTarget struct {
+	label string
+	scan  func(*yara.Scanner) error
+	// describeError explains a scan error and whether to give up on the target
+	describeError func(error) (string, bool)
+	// hash computes the target's hashes, it is nil for processes
+	hash func() (*resultFile, error)
+}
+
+// fileTarget scans a file, files up to preloadLimit are read once for both scanning and hashing
+func fileTarget(path string, info os.FileInfo) scanTarget {
+	target := scanTarget{
+		label: path,
+		scan:  func(s *yara.Scanner) error { return s.ScanFile(path) },
+		describeError: func(err error) (string, bool) {
+			return describeScanError(path, err)
+		},
+		hash: func() (*resultFile, error) { return hashFile(path) },
+	}
+	if info.Size() <= preloadLimit {
+		if data, err := ioutil.ReadFile(path); err == nil {
+			modTime := info.ModTime()
+			target.scan = func(s *yara.Scanner) error { return s.ScanMem(data) }
+			target.hash = func() (*resultFile, error) {
+				file, err := hashBytes(data)
+				if file != nil {
+					file.ModTime = &modTime
+				}
+				return file, err
+			}
 		}
+	}
+	return target
+}
+
+func bufferTarget(label string, data []byte) scanTarget {
+	return scanTarget{
+		label: label,
+		scan:  func(s *yara.Scanner) error { return s.ScanMem(data) },
+		describeError: func(err error) (string, bool) {
+			return err.Error(), false
+		},
+		hash: func() (*resultFile, error) { return hashBytes(data) },
+	}
+}
+
+func processTarget(pid int) scanTarget {
+	return scanTarget{
+		label: processLabel(pid),
+		scan:  func(s *yara.Scanner) error { return s.ScanProc(pid) },
+		describeError: func(err error) (string, bool) {
+			if isAttachError(err) {
+				return fmt.Sprintf("permission denied: %s (scanning other users' processes requires root)", err), true
+			}
+			return err.Error(), false
+		},
+	}
+}
 
-		// check if it is a regular file (not dir)
-		if info.Mode().IsRegular() {
-			var m []yara.MatchRule
-			scanResults[path] = m
+// scanWith scans a target with every ruleset
+func scanWith(scanner *yaya.Scanner, target scanTarget, opts scanOptions) *scanResult {
+	result := &scanResult{Path: target.label}
+	scanner.Scan(target.scan, func(rs yaya.RulesetResult) bool {
+		for _, match := range rs.Matches {
+			result.Matches = append(result.Matches, newResultMatch(rs.Ruleset, match, opts.snippetBytes))
+		}
+		if rs.Err == nil {
+			return true
+		}
+		Warning(fmt.Errorf("%s: %s", target.label, rs.Err))
+		if isTimeout(rs.Err) {
+			// other rulesets may still finish in time
+			result.addError(fmt.Sprintf("timed out after %s with ruleset %s", opts.timeout, rs.Ruleset))
+			return true
 		}
-		return nil
+		msg, abandon := target.describeError(rs.Err)
+		result.addError(msg)
+		return !abandon
 	})
+	applyScores(result, opts.matchFilter)
+	if target.hash != nil && (len(result.Matches) > 0 || opts.hashAll) {
+		file, err := target.hash()
+		if err != nil {
+			result.addError(fmt.Sprintf("could not hash: %s", err))
+		}
+		result.File = file
+	}
+	opts.allowlist.suppress(result)
+	return result
+}
 
-	db.Where("enabled = ?", true).Find(&rulesets)
+// errScanStopped stops walking the scan path when the scan is interrupted
+var errScanStopped = errors.New("scan stopped")
 
-	for _, ruleset := range rulesets {
-		c, err := yara.NewCompiler()
+// runScan Scan a path recursively with every rule in the database
+// Results are printed and written out as soon as each file is done.
+func runScan(store *yaya.Store, rulesets *yaya.RulesetManager, scanPath string, opts scanOptions) {
+	db := store.DB()
+	scanner, err := yaya.NewScanner(store, yaya.ScannerOptions{Dedup: opts.dedup, DisableSlow: opts.disableSlow, Timeout: opts.timeout})
+	if err != nil {
+		log.Panicf("Failed to compile rules: %s", err)
+	}
+
+	// every goroutine scanning files needs its own scanner
+	workers := []*yaya.Scanner{scanner}
+	for len(workers) < opts.concurrency {
+		clone, err := scanner.Clone()
 		if err != nil {
-			log.Fatalf("Failed to initialize YARA compiler: %s", err)
+			log.Panicf("Failed to create scanner: %s", err)
 		}
+		workers = append(workers, clone)
+	}
 
-		db.Model(&ruleset).Where("enabled = ?", true).Related(&rules)
-
-		log.Printf("Scanning with %s. Compiling %d rules\n", ruleset.Name, len(rules))
-		for _, rule := range rules {
-			f, err := os.Open(rule.Path)
-			if err != nil {
-				log.Printf("Could not open rule file %s: %s\n", rule.Path, err)
-        break
-			}
-			err = c.AddFile(f, rule.Namespace)
-			f.Close()
-			if err != nil {
-				log.Printf("Could not parse rule file %s: %s", rule.Path, err)
-				break
-			}
+	version := scanner.RulesVersion()
+	stateVersion := coverageVersion(version, opts)
+	cp := startScan(db, opts.resume, Scan{
+		Path:            scanPath,
+		Output:          opts.output,
+		Format:          opts.format,
+		RulesVersion:    version,
+		RulesetVersions: strings.Join(rulesets.Versions(), "\n"),
+	})
+	if opts.resume != 0 {
+		// carry on where the interrupted scan left off
+		scanPath = cp.scan.Path
+		opts.output = cp.scan.Output
+		if cp.scan.Format != "" {
+			opts.format = cp.scan.Format
+		}
+	} else if opts.output == "" {
+		opts.output = defaultOutputPath(cp.scan.ID, opts.format)
+		db.Model(cp.scan).Update("output", opts.output)
+	}
+	// a resumed scan adds to its output, unless the rules changed and it starts over
+	appending := opts.resume != 0 && !cp.reset
+	if appending && opts.output != "-" {
+		if later := cp.scan.overwrittenBy(db); later != 0 {
+			// the results there are the later scan's, skipping their paths would leave them unscanned
+			log.Fatalf("Scan %d wrote its results to %s too, scan %d can't be resumed", later, opts.output, cp.scan.ID)
 		}
-		rules, err := c.GetRules()
+		written, err := recoverOutput(opts.output, opts.format)
 		if err != nil {
-			log.Panicf("Failed to compile rules: %s", err)
+			log.Fatalf("Could not read output %s: %s", opts.output, err)
 		}
-		for path, matches := range scanResults {
-			var results yara.MatchRules
-			err := rules.ScanFile(path, 0, 0, &results)
-			if err != nil {
-				Warning(err)
-			}
-			scanResults[path] = append(matches, results...)
+		cp.skip(written)
+	}
+	out, err := newResultWriter(opts.output, opts.format, appending)
+	if err != nil {
+		log.Fatalf("Could not open output %s: %s", opts.output, err)
+	}
+	defer out.Close()
+
+	opts.allowlist = loadAllowlist(db)
+	// mu keeps results from interleaving and guards the counts
+	var mu sync.Mutex
+	var matched []scoredFile
+	var suppressed, unchanged int
+	// wg waits for the goroutines scanning files, stopping is closed when the scan is interrupted
+	var wg sync.WaitGroup
+	stopping := make(chan struct{})
+	interrupted := func() bool {
+		select {
+		case <-stopping:
+			return true
+		default:
+			return false
 		}
-
 	}
-	printMatches(scanResults)
-	saveMatchesJSON(scanResults)
-}
-
-// Export rules in plaintext instead of compiled
-func exportRules(outputPath string) {
-	db := openDB()
-	defer db.Close()
-
-	db.Where("enabled = ?", true).Find(&rulesets)
+	cp.handleInterrupt(func() {
+		close(stopping)
+		wg.Wait()
+	}, &mu)
+	// report prints, writes out and checkpoints a result
+	report := func(result *scanResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		printResult(result, opts.snippetBytes > 0)
+		out.write(result)
+		cp.checkpoint(result.Path)
+		suppressed += result.Suppressed
+		if len(result.Matches) > 0 {
+			matched = append(matched, scoredFile{path: result.Path, score: result.Score, matches: len(result.Matches)})
+		}
+	}
+	emit := func(scanner *yaya.Scanner, target scanTarget) *scanResult {
+		if cp.scanned(target.label) {
+			return nil
+		}
+		result := scanWith(scanner, target, opts)
+		report(result)
+		return result
+	}
 
-    outFile, err := os.Create(outputPath)
-	if err != nil {
-		log.Printf("Could not open rule file %s: %s\n", outputPath, err)
+	var pids []int
+	if opts.pid != 0 {
+		pids = append(pids, opts.pid)
+	}
+	if opts.allProcesses {
+		all, err := listProcesses()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		pids = append(pids, all...)
+	}
+	for _, pid := range pids {
+		if interrupted() {
+			break
+		}
+		emit(scanner, processTarget(pid))
 	}
-	defer outFile.Close()
 
-	for _, ruleset := range rulesets {
-		db.Model(&ruleset).Where("enabled = ?", true).Related(&rules)
-		for _, rule := range rules {
-			dat, err := ioutil.ReadFile(rule.Path)
-			if err != nil {
-				log.Printf("Could not open rule file %s: %s\n", rule.Path, err)
+	if scanPath == "-" {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatalf("Could not read stdin: %s", err)
+		}
+		emit(scanner, bufferTarget(stdinLabel, data))
+	} else if scanPath != "" {
+		scanFile := func(scanner *yaya.Scanner, path string, info os.FileInfo) {
+			if !opts.full && unchangedSinceCleanScan(db, path, info, stateVersion) {
+				mu.Lock()
+				unchanged++
+				mu.Unlock()
 				return
 			}
+			target := fileTarget(path, info)
+			var result *scanResult
+			if !cp.scanned(path) {
+				result = scanWith(scanner, target, opts)
+			}
+			// an archive only scanned clean if all of its members did
+			clean := result != nil && result.clean()
+			// memberRules are the rules that matched members, the archive is quarantined for them too
+			var memberRules []string
+			if opts.archives && isArchive(path) {
+				// members are scanned as they are extracted, so only one is in memory at a time
+				errs := expandArchiveFile(path, opts.archive, func(name string, data []byte) {
+					member := emit(scanner, bufferTarget(name, data))
+					if member == nil || !member.clean() {
+						clean = false
+					}
+					if member == nil {
+						return
+					}
+					for _, match := range member.Matches {
+						memberRules = append(memberRules, fmt.Sprintf("[%s] %s in %s", match.Namespace, match.Rule, strings.TrimPrefix(name, path+archiveSeparator)))
+					}
+				})
+				for _, err := range errs {
+					clean = false
+					if result == nil {
+						Warning(err)
+					} else {
+						result.addError(err.Error())
+					}
+				}
+			}
+			if result != nil {
+				// the archive is reported after its members, with what went wrong expanding it
+				report(result)
+				file := result.File
+				if file == nil && clean {
+					// preloaded files are hashed from memory, without reading them again
+					file, _ = target.hash()
+				}
+				recordFileState(db, path, info, stateVersion, clean, file)
+				// quarantine last, so the members of a matched archive are scanned before it is moved
+				if opts.quarantine != "" && (len(result.Matches) > 0 || len(memberRules) > 0) {
+					quarantineFile(db, opts.quarantine, cp.scan.ID, result, memberRules, opts.quarantineCopy)
+				}
+			}
+		}
 
-			outFile.Write(dat)
-			outFile.WriteString("\n")
-			outFile.Sync()
+		type walkedFile struct {
+			path string
+			info os.FileInfo
+		}
+		files := make(chan walkedFile)
+		for _, worker := range workers {
+			wg.Add(1)
+			go func(worker *yaya.Scanner) {
+				defer wg.Done()
+				for file := range files {
+					scanFile(worker, file.path, file.info)
+				}
+			}(worker)
+		}
+		err := walkScanPath(scanPath, opts.filter, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				// unreadable directories and files that vanished are recorded and the walk carries on
+				if !cp.scanned(path) {
+					report(&scanResult{Path: path, Errors: []string{describeWalkError(err)}})
+				}
+				return nil
+			}
+			select {
+			case files <- walkedFile{path: path, info: info}:
+				return nil
+			case <-stopping:
+				return errScanStopped
+			}
+		})
+		close(files)
+		wg.Wait()
+		if err != errScanStopped {
+			Warning(err)
+		}
+		if unchanged > 0 {
+			log.Printf("Skipped %d files that are unchanged since they last scanned clean, use -full to scan them anyway", unchanged)
 		}
 	}
 
-
-}
-
-func exportRulesCompiled(outputPath string) {
-	db := openDB()
-	defer db.Close()
-
-	db.Where("enabled = ?", true).Find(&rulesets)
-
-	c, err := yara.NewCompiler()
-	if err != nil {
-		log.Fatalf("Failed to initialize YARA compiler: %s", err)
+	if interrupted() {
+		// the interrupt handler records the interruption and exits
+		select {}
 	}
-
-	for _, ruleset := range rulesets {
-		db.Model(&ruleset).Where("enabled = ?", true).Related(&rules)
-		for _, rule := range rules {
-			f, err := os.Open(rule.Path)
-			if err != nil {
-				log.Printf("Could not open rule file %s: %s\n", rule.Path, err)
+	cp.finish(scanCompleted)
+	if suppressed > 0 {
+		log.Printf("Suppressed %d matches on allowlisted files", suppressed)
+	}
+	if opts.sortScore && len(matched) > 0 {
+		printScoreSummary(matched)
+	}
+	log.Printf("%s output written to %s", opts.format, opts.output)
+	if opts.profile {
+		profile := newScanProfile()
+		for i, rs := range scanner.Rulesets {
+			scanTime := rs.Scan
+			for _, worker := range workers[1:] {
+				scanTime += worker.Rulesets[i].Scan
 			}
-			err = c.AddFile(f, rule.Namespace)
-			f.Close()
-			if err != nil {
-				log.Printf("Could not parse rule file %s: %s", rule.Path, err)
-				break
+			profile.addRuleset(rs.Name, rs.Rules, rs.Compile, scanTime)
+		}
+		for _, worker := range workers {
+			for _, rs := range worker.Rulesets {
+				profile.addRuleCosts(rs.Scanner)
 			}
 		}
+		if opts.output == "-" {
+			// keep stdout for the results
+			profile.print(os.Stderr)
+		} else {
+			profile.print(os.Stdout)
+		}
+	}
+}
+
+// exportRules exports rules in plaintext instead of compiled
+func exportRules(store *yaya.Store, outputPath string, dedup string) {
+	outFile, err := os.Create(outputPath)
+	if err != nil {
+		log.Fatalf("Could not open rule file %s: %s\n", outputPath, err)
 	}
-	mainRule, err := c.GetRules()
+	defer outFile.Close()
+	Warning(store.ExportRules(outFile, dedup))
+}
+
+func exportRulesCompiled(store *yaya.Store, outputPath string, dedup string) {
+	mainRule, err := store.CompileRules(dedup)
 	if err != nil {
 		log.Panicf("Failed to compile rules: %s", err)
 	}